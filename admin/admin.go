@@ -0,0 +1,283 @@
+// Package admin implements the HTTP API for controlling the players of the bot.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"discobot"
+
+	dg "github.com/andersfylling/disgord"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// enqueueTimeout limits fetching the metadata of the enqueued track.
+	enqueueTimeout = 2 * time.Minute
+
+	sessionCookie = "discobot_admin"
+)
+
+type handler struct {
+	bot      *discobot.DiscoBot
+	token    string
+	sessions *sessions
+	logger   *slog.Logger
+}
+
+// NewHandler returns the handler of the API and the dashboard. API requests must have
+// the bearer token or the session cookie:
+//
+//	POST   /api/session                start the session of the dashboard {"token": "..."}
+//	GET    /api/events                 player states as server-sent events
+//	GET    /api/guilds                 list guilds
+//	GET    /api/guilds/{id}            player status with the queue
+//	POST   /api/guilds/{id}/queue      enqueue {"url": "...", "channel_id": "..."}
+//	DELETE /api/guilds/{id}/queue      clear the queue
+//	POST   /api/guilds/{id}/queue/move move the queued track {"from": 2, "to": 0}
+//	POST   /api/guilds/{id}/pause      pause
+//	POST   /api/guilds/{id}/resume     resume
+//	POST   /api/guilds/{id}/skip       skip the current track
+//	POST   /api/guilds/{id}/stop       clear the queue and skip the current track
+//	PUT    /api/guilds/{id}/volume     set volume {"volume": 100}
+func NewHandler(bot *discobot.DiscoBot, token string, logger *slog.Logger) http.Handler {
+	h := &handler{bot: bot, token: token, sessions: newSessions(), logger: logger}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", dashboardHandler())
+	mux.HandleFunc("/api/session", h.startSession)
+	mux.Handle("/api/events", h.authenticate(http.HandlerFunc(h.events)))
+	mux.Handle("/api/guilds", h.authenticate(h))
+	mux.Handle("/api/guilds/", h.authenticate(h))
+	return mux
+}
+
+// authenticate rejects the requests without the token.
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/guilds"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.listGuilds(w)
+		return
+	}
+
+	id, action, _ := strings.Cut(path, "/")
+	guildID, err := dg.GetSnowflake(id)
+	if err != nil || guildID.IsZero() {
+		writeError(w, http.StatusBadRequest, "invalid guild ID")
+		return
+	}
+
+	log := h.logger.With("guild", guildID, "method", r.Method, "action", action)
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.status(w, guildID)
+		return
+	case action == "queue" && r.Method == http.MethodPost:
+		err = h.enqueue(r, guildID)
+	case action == "queue" && r.Method == http.MethodDelete:
+		err = h.bot.ClearQueue(guildID)
+	case action == "queue/move" && r.Method == http.MethodPost:
+		err = h.moveTrack(r, guildID)
+	case action == "pause" && r.Method == http.MethodPost:
+		err = h.bot.Pause(guildID)
+	case action == "resume" && r.Method == http.MethodPost:
+		err = h.bot.Resume(guildID)
+	case action == "skip" && r.Method == http.MethodPost:
+		err = h.bot.Skip(guildID)
+	case action == "stop" && r.Method == http.MethodPost:
+		err = h.bot.Stop(guildID)
+	case action == "volume" && r.Method == http.MethodPut:
+		err = h.setVolume(r, guildID)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		h.writeOperationError(w, log, err)
+		return
+	}
+
+	log.Info("admin operation is done")
+	h.status(w, guildID)
+}
+
+func (h *handler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		return h.validToken(token)
+	}
+	// EventSource of the dashboard can't set headers
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	return h.sessions.valid(cookie.Value)
+}
+
+func (h *handler) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+type sessionRequest struct {
+	Token string `json:"token"`
+}
+
+// startSession sets the cookie with a new session ID if the token is valid.
+func (h *handler) startSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req sessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if !h.validToken(req.Token) {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	sessionID, err := h.sessions.start()
+	if err != nil {
+		h.logger.Error("failed to start the session", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listGuilds(w http.ResponseWriter) {
+	guilds := []discobot.PlayerStatus{}
+	for _, guildID := range h.bot.Guilds() {
+		status, err := h.bot.PlayerStatus(guildID)
+		if err != nil {
+			h.logger.Error("failed to get the player status", "guild", guildID, "err", err)
+			continue
+		}
+		guilds = append(guilds, status)
+	}
+	writeJSON(w, http.StatusOK, guilds)
+}
+
+func (h *handler) status(w http.ResponseWriter, guildID dg.Snowflake) {
+	status, err := h.bot.PlayerStatus(guildID)
+	if err != nil {
+		h.writeOperationError(w, h.logger.With("guild", guildID), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+type enqueueRequest struct {
+	URL       string       `json:"url"`
+	ChannelID dg.Snowflake `json:"channel_id"`
+}
+
+func (h *handler) enqueue(r *http.Request, guildID dg.Snowflake) error {
+	var req enqueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &requestError{msg: "invalid request body: " + err.Error()}
+	}
+	if req.URL == "" || req.ChannelID.IsZero() {
+		return &requestError{msg: "url and channel_id are required"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), enqueueTimeout)
+	defer cancel()
+
+	return h.bot.Enqueue(ctx, guildID, req.ChannelID, req.URL)
+}
+
+type moveRequest struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (h *handler) moveTrack(r *http.Request, guildID dg.Snowflake) error {
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &requestError{msg: "invalid request body: " + err.Error()}
+	}
+	return h.bot.MoveTrack(guildID, req.From, req.To)
+}
+
+type volumeRequest struct {
+	Volume int `json:"volume"`
+}
+
+func (h *handler) setVolume(r *http.Request, guildID dg.Snowflake) error {
+	var req volumeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &requestError{msg: "invalid request body: " + err.Error()}
+	}
+	return h.bot.SetVolume(guildID, req.Volume)
+}
+
+// requestError is an invalid request.
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func (h *handler) writeOperationError(w http.ResponseWriter, log *slog.Logger, err error) {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		writeError(w, http.StatusBadRequest, reqErr.msg)
+	case errors.Is(err, discobot.ErrUnknownGuild):
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		if msg, ok := discobot.UserMessage(err); ok {
+			log.Info("admin operation is rejected", "err", err)
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		log.Error("admin operation failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFS embed.FS
+
+// dashboardHandler serves the static files of the dashboard, they have no data without the session.
+func dashboardHandler() http.Handler {
+	root, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
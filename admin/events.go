@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// refreshInterval is the interval of resending the playing players, so the progress doesn't drift
+// and the events dropped for slow clients are eventually delivered.
+const refreshInterval = 5 * time.Second
+
+// events streams the player states: the states of all players are sent first,
+// then the state of a player is sent every time it changes.
+func (h *handler) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	changes, unsubscribe := h.bot.SubscribePlayers()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	refresh := func(playingOnly bool) error {
+		for _, guildID := range h.bot.Guilds() {
+			if err := h.sendPlayer(w, guildID, playingOnly); err != nil {
+				return err
+			}
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := refresh(false); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case guildID := <-changes:
+			if err := h.sendPlayer(w, guildID, false); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if err := refresh(true); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h *handler) sendPlayer(w http.ResponseWriter, guildID dg.Snowflake, playingOnly bool) error {
+	status, err := h.bot.PlayerStatus(guildID)
+	if err != nil {
+		h.logger.Error("failed to get the player status", "guild", guildID, "err", err)
+		return nil
+	}
+	if playingOnly && status.Status != "playing" {
+		return nil
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: player\ndata: %s\n\n", data)
+	return err
+}
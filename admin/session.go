@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTTL is the lifetime of a dashboard session, users sign in again after it.
+const sessionTTL = 12 * time.Hour
+
+// sessions keeps the IDs of the dashboard sessions, so the cookie doesn't hold the admin token.
+// Sessions are kept in memory and end on restart.
+type sessions struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+func newSessions() *sessions {
+	return &sessions{expires: make(map[string]time.Time)}
+}
+
+// start creates a session and returns its ID.
+func (s *sessions) start() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for sessionID, expires := range s.expires {
+		if !now.Before(expires) {
+			delete(s.expires, sessionID)
+		}
+	}
+	s.expires[id] = now.Add(sessionTTL)
+	return id, nil
+}
+
+// valid reports whether the session exists and hasn't expired.
+func (s *sessions) valid(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.expires[id]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(expires) {
+		delete(s.expires, id)
+		return false
+	}
+	return true
+}
+
+// secureRequest reports whether the request is served over TLS,
+// directly or behind a proxy terminating it, e.g. on fly.io.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,209 @@
+"use strict";
+
+const guilds = document.getElementById("guilds");
+const template = document.getElementById("guild-template");
+const login = document.getElementById("login");
+const connection = document.getElementById("connection");
+
+// players are the last states received from the server by guild ID.
+const players = new Map();
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: body === undefined ? {} : {"Content-Type": "application/json"},
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    showLogin();
+  }
+  if (!resp.ok) {
+    const data = await resp.json().catch(() => ({}));
+    throw new Error(data.error || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function formatTime(seconds) {
+  seconds = Math.floor(seconds);
+  const m = Math.floor(seconds / 60);
+  const s = String(seconds % 60).padStart(2, "0");
+  return `${m}:${s}`;
+}
+
+function guildElement(id) {
+  let el = document.getElementById(`guild-${id}`);
+  if (el) {
+    return el;
+  }
+
+  el = template.content.firstElementChild.cloneNode(true);
+  el.id = `guild-${id}`;
+  el.querySelector(".guild-id").textContent = id;
+
+  const error = el.querySelector(".error");
+  const run = (promise) => promise.then(() => { error.textContent = ""; }, (err) => { error.textContent = err.message; });
+
+  el.querySelectorAll("button[data-action]").forEach((button) => {
+    const action = button.dataset.action;
+    button.addEventListener("click", () => {
+      run(action === "clear"
+        ? api("DELETE", `/api/guilds/${id}/queue`)
+        : api("POST", `/api/guilds/${id}/${action}`));
+    });
+  });
+
+  const volume = el.querySelector(".volume");
+  volume.addEventListener("change", () => {
+    run(api("PUT", `/api/guilds/${id}/volume`, {volume: Number(volume.value)}));
+  });
+
+  el.querySelector(".enqueue").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const form = event.target;
+    run(api("POST", `/api/guilds/${id}/queue`, {url: form.url.value, channel_id: form.channel_id.value})
+      .then(() => form.url.value = ""));
+  });
+
+  const queue = el.querySelector(".queue");
+  let dragged = null;
+  queue.addEventListener("dragstart", (event) => {
+    dragged = event.target.closest("li");
+    dragged.classList.add("dragging");
+  });
+  queue.addEventListener("dragend", () => {
+    dragged?.classList.remove("dragging");
+    queue.querySelectorAll(".over").forEach((li) => li.classList.remove("over"));
+    dragged = null;
+  });
+  queue.addEventListener("dragover", (event) => {
+    const li = event.target.closest("li");
+    if (!dragged || !li) {
+      return;
+    }
+    event.preventDefault();
+    queue.querySelectorAll(".over").forEach((el) => el.classList.remove("over"));
+    li.classList.add("over");
+  });
+  queue.addEventListener("drop", (event) => {
+    const li = event.target.closest("li");
+    if (!dragged || !li) {
+      return;
+    }
+    event.preventDefault();
+    const from = Number(dragged.dataset.index);
+    const to = Number(li.dataset.index);
+    if (from !== to) {
+      run(api("POST", `/api/guilds/${id}/queue/move`, {from, to}));
+    }
+  });
+
+  guilds.appendChild(el);
+  return el;
+}
+
+function render(player) {
+  const el = guildElement(player.guild_id);
+  el.querySelector(".status").textContent = player.status;
+
+  const title = el.querySelector(".title");
+  if (player.current) {
+    title.textContent = player.current.title || player.current.url;
+    title.href = player.current.url;
+  } else {
+    title.textContent = "Nothing is playing";
+    title.removeAttribute("href");
+  }
+
+  const volume = el.querySelector(".volume");
+  if (document.activeElement !== volume) {
+    volume.value = player.volume;
+  }
+  el.querySelector(".volume-value").textContent = player.volume;
+
+  const queue = el.querySelector(".queue");
+  queue.replaceChildren(...player.queue.map((track, index) => {
+    const li = document.createElement("li");
+    li.draggable = true;
+    li.dataset.index = index;
+    li.textContent = track.title || track.url;
+    return li;
+  }));
+
+  renderProgress(el, player);
+}
+
+// renderProgress draws the position of the current track, it advances locally between the events.
+function renderProgress(el, player) {
+  const bar = el.querySelector(".bar");
+  const time = el.querySelector(".time");
+  const current = player.current;
+  if (!current) {
+    bar.style.width = "0";
+    time.textContent = "";
+    return;
+  }
+
+  let elapsed = current.elapsed || 0;
+  if (player.status === "playing") {
+    elapsed += (Date.now() - player.receivedAt) / 1000;
+  }
+  if (current.duration) {
+    elapsed = Math.min(elapsed, current.duration);
+    bar.style.width = `${(elapsed / current.duration) * 100}%`;
+    time.textContent = `${formatTime(elapsed)} / ${formatTime(current.duration)}`;
+  } else {
+    bar.style.width = "100%";
+    time.textContent = formatTime(elapsed);
+  }
+}
+
+let source = null;
+
+function connect() {
+  source?.close();
+  source = new EventSource("/api/events");
+  source.addEventListener("open", () => {
+    connection.textContent = "online";
+    connection.className = "online";
+  });
+  source.addEventListener("error", () => {
+    connection.textContent = "offline";
+    connection.className = "offline";
+    // EventSource doesn't expose the status, check whether the session is valid
+    api("GET", "/api/guilds").catch(() => {});
+  });
+  source.addEventListener("player", (event) => {
+    const player = JSON.parse(event.data);
+    player.receivedAt = Date.now();
+    players.set(player.guild_id, player);
+    render(player);
+  });
+}
+
+function showLogin() {
+  source?.close();
+  source = null;
+  login.hidden = false;
+}
+
+login.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  try {
+    await api("POST", "/api/session", {token: login.token.value});
+    login.hidden = true;
+    login.reset();
+    document.getElementById("login-error").textContent = "";
+    connect();
+  } catch (err) {
+    document.getElementById("login-error").textContent = err.message;
+  }
+});
+
+setInterval(() => {
+  for (const player of players.values()) {
+    renderProgress(guildElement(player.guild_id), player);
+  }
+}, 1000);
+
+connect();
@@ -0,0 +1,252 @@
+// Package audiocache keeps the downloaded audio of tracks on disk, so tracks played again
+// aren't downloaded and encoded again. The least recently used files are evicted when the cache is full.
+package audiocache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"discobot/metrics"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	fileExt = ".audio"
+	tempExt = ".tmp"
+)
+
+// ErrTooLarge is returned by Writer.Write when the audio doesn't fit the cache.
+var ErrTooLarge = errors.New("audio is larger than the cache")
+
+type Config struct {
+	Dir string
+	// MaxSize is the total size of the cached files in bytes.
+	MaxSize int64
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Cache is the cache of audio files by key, e.g. the video and the volume.
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru has the most recently used files at the front.
+	lru  *list.List
+	size int64
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// New opens the cache directory, the files left from the previous run are kept.
+func New(cfg Config) (*Cache, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.MaxSize <= 0 {
+		return nil, errors.New("max size of the audio cache must be positive")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Open returns the cached audio of the key, false is returned if the audio isn't cached.
+func (c *Cache) Open(key string) (*os.File, bool) {
+	name := fileName(key)
+
+	c.mu.Lock()
+	elem, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		metrics.AudioCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	path := filepath.Join(c.cfg.Dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		c.cfg.Logger.Warn("failed to open the cached audio", "err", err)
+		c.mu.Lock()
+		if elem, ok := c.entries[name]; ok {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		metrics.AudioCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	// the modification time keeps the order of use between restarts
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	metrics.AudioCacheRequests.WithLabelValues("hit").Inc()
+	return file, true
+}
+
+// Create returns the writer of the audio of the key, it is cached on Commit.
+func (c *Cache) Create(key string) (*Writer, error) {
+	name := fileName(key)
+	file, err := os.CreateTemp(c.cfg.Dir, name+".*"+tempExt)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{cache: c, name: name, file: file}, nil
+}
+
+// Size returns the total size of the cached files.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// add caches the written file and evicts the least recently used ones, the lock must be held.
+func (c *Cache) add(name string, size int64) {
+	if elem, ok := c.entries[name]; ok {
+		e := elem.Value.(*entry)
+		c.size += size - e.size
+		e.size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[name] = c.lru.PushFront(&entry{name: name, size: size})
+		c.size += size
+	}
+
+	for c.size > c.cfg.MaxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	metrics.AudioCacheSize.Set(float64(c.size))
+}
+
+// remove deletes the file of the entry, the lock must be held.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.name)
+	c.size -= e.size
+	metrics.AudioCacheSize.Set(float64(c.size))
+
+	if err := os.Remove(filepath.Join(c.cfg.Dir, e.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.cfg.Logger.Warn("failed to remove the cached audio", "err", err)
+	}
+}
+
+// load indexes the files of the directory by the modification time and removes unfinished ones.
+func (c *Cache) load() error {
+	files, err := os.ReadDir(c.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []loaded
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		name := file.Name()
+		if strings.HasSuffix(name, tempExt) {
+			_ = os.Remove(filepath.Join(c.cfg.Dir, name))
+			continue
+		}
+		if filepath.Ext(name) != fileExt {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, loaded{name: name, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		c.add(e.name, e.size)
+	}
+	c.cfg.Logger.Info("loaded the audio cache", "files", c.lru.Len(), "size", c.size)
+	return nil
+}
+
+func fileName(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:]) + fileExt
+}
+
+// Writer writes the audio into a temporary file, it is moved to the cache on Commit.
+// Either Commit or Abort must be called.
+type Writer struct {
+	cache *Cache
+	name  string
+	file  *os.File
+	size  int64
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.size+int64(len(p)) > w.cache.cfg.MaxSize {
+		return 0, ErrTooLarge
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit adds the written audio to the cache.
+func (w *Writer) Commit() error {
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+
+	w.cache.mu.Lock()
+	defer w.cache.mu.Unlock()
+
+	if err := os.Rename(w.file.Name(), filepath.Join(w.cache.cfg.Dir, w.name)); err != nil {
+		_ = os.Remove(w.file.Name())
+		return fmt.Errorf("audio cache: %w", err)
+	}
+	w.cache.add(w.name, w.size)
+	return nil
+}
+
+// Abort removes the written audio.
+func (w *Writer) Abort() {
+	w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
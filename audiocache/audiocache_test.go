@@ -0,0 +1,223 @@
+package audiocache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func newTestCache(t *testing.T, dir string, maxSize int64) *Cache {
+	t.Helper()
+
+	c, err := New(Config{Dir: dir, MaxSize: maxSize, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func put(t *testing.T, c *Cache, key string, data []byte) {
+	t.Helper()
+
+	w, err := c.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, c *Cache, key string) ([]byte, bool) {
+	t.Helper()
+
+	file, ok := c.Open(key)
+	if !ok {
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, true
+}
+
+// files returns the names of the files in the directory.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCommitMovesTheTemporaryFile(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCache(t, dir, 100)
+
+	w, err := c.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the audio isn't cached until it is committed
+	if _, ok := c.Open("a"); ok {
+		t.Fatal("the audio is cached before Commit")
+	}
+	names := files(t, dir)
+	if len(names) != 1 || !strings.HasSuffix(names[0], tempExt) {
+		t.Fatalf("got files %v before Commit, want a temporary file", names)
+	}
+
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := read(t, c, "a"); !ok || string(data) != "audio" {
+		t.Fatalf("got %q, %v, want the committed audio", data, ok)
+	}
+	if names := files(t, dir); len(names) != 1 || names[0] != fileName("a") {
+		t.Errorf("got files %v after Commit, want %s", names, fileName("a"))
+	}
+	if c.Size() != 5 {
+		t.Errorf("got size %d, want 5", c.Size())
+	}
+}
+
+func TestAbortLeavesNoEntry(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		// e.g. the download failed in the middle of the track
+		{name: "partial audio", data: []byte("par")},
+		{name: "audio larger than the cache", data: bytes.Repeat([]byte{1}, 11), err: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := newTestCache(t, dir, 10)
+
+			w, err := c.Create("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(tt.data); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			w.Abort()
+
+			if _, ok := c.Open("a"); ok {
+				t.Error("the aborted audio is cached")
+			}
+			if names := files(t, dir); len(names) != 0 {
+				t.Errorf("got files %v after Abort", names)
+			}
+			if c.Size() != 0 {
+				t.Errorf("got size %d, want 0", c.Size())
+			}
+		})
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCache(t, dir, 10)
+
+	put(t, c, "a", []byte("aaaa"))
+	put(t, c, "b", []byte("bbbb"))
+	// a is used, so b is evicted by c
+	if _, ok := read(t, c, "a"); !ok {
+		t.Fatal("a isn't cached")
+	}
+	put(t, c, "c", []byte("cccc"))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := read(t, c, key); ok != want {
+			t.Errorf("%s: got cached %v, want %v", key, ok, want)
+		}
+	}
+	if c.Size() != 8 {
+		t.Errorf("got size %d, want 8", c.Size())
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileName("b"))); !os.IsNotExist(err) {
+		t.Errorf("the file of the evicted audio is kept: %v", err)
+	}
+
+	// the audio of the whole cache evicts everything else
+	put(t, c, "d", bytes.Repeat([]byte{'d'}, 10))
+	if names := files(t, dir); len(names) != 1 || names[0] != fileName("d") {
+		t.Errorf("got files %v, want only d", names)
+	}
+}
+
+func TestCommitReplacesTheAudio(t *testing.T) {
+	c := newTestCache(t, t.TempDir(), 10)
+
+	put(t, c, "a", []byte("aaaa"))
+	put(t, c, "a", []byte("aa"))
+
+	if data, ok := read(t, c, "a"); !ok || string(data) != "aa" {
+		t.Errorf("got %q, %v, want the replaced audio", data, ok)
+	}
+	if c.Size() != 2 {
+		t.Errorf("got size %d, want 2", c.Size())
+	}
+}
+
+func TestNewLoadsTheDirectory(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCache(t, dir, 10)
+	put(t, c, "a", []byte("aaaa"))
+	put(t, c, "b", []byte("bbbb"))
+
+	// a is the most recently used one by the modification time
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, fileName("b")), past, past); err != nil {
+		t.Fatal(err)
+	}
+	// the temporary file of the download interrupted by the restart
+	if err := os.WriteFile(filepath.Join(dir, fileName("c")+".123"+tempExt), []byte("cc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestCache(t, dir, 10)
+	if loaded.Size() != 8 {
+		t.Errorf("got size %d, want 8", loaded.Size())
+	}
+	if names := files(t, dir); len(names) != 2 {
+		t.Errorf("got files %v, want the temporary file removed", names)
+	}
+
+	put(t, loaded, "d", []byte("dddd"))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		if _, ok := read(t, loaded, key); ok != want {
+			t.Errorf("%s: got cached %v, want %v", key, ok, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"discobot"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+const commandsUsage = `Usage: discobot commands register|unregister [-guild ID] [config flags]
+
+Registers or removes the slash commands globally or in the guild.
+`
+
+// runCommands runs the "commands" subcommand.
+func runCommands(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return errors.New("action is not provided")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("commands "+action, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), commandsUsage)
+		fs.PrintDefaults()
+	}
+	guild := fs.String("guild", "", "guild ID, commands are global if it is not set")
+
+	cfg, err := loadConfig(fs, args[1:])
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	var guildID dg.Snowflake
+	if *guild != "" {
+		id, err := strconv.ParseUint(*guild, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid guild ID %q", *guild)
+		}
+		guildID = dg.Snowflake(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	registry := discobot.NewCommandRegistry(cfg.Token)
+
+	var changed bool
+	switch action {
+	case "register":
+		changed, err = registry.Register(ctx, guildID)
+	case "unregister":
+		changed, err = registry.Unregister(ctx, guildID)
+	default:
+		fmt.Fprint(os.Stderr, commandsUsage)
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		fmt.Printf("commands are %sed\n", action)
+	} else {
+		fmt.Println("commands are up to date")
+	}
+
+	return nil
+}
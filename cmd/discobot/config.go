@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"discobot"
+	"discobot/library"
+	"discobot/settings"
+	"discobot/ytdlp"
+
+	dg "github.com/andersfylling/disgord"
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// minAdminTokenLength rejects guessable admin tokens.
+	minAdminTokenLength = 16
+	// maxPreBuffer keeps the pre-buffer within the buffer of decoded frames.
+	maxPreBuffer = 10 * time.Second
+)
+
+// Config is loaded from the config file, environment variables and flags,
+// each next source overrides the previous one.
+type Config struct {
+	Token string `yaml:"token"`
+
+	YtDlpPath  string `yaml:"ytdlp_path"`
+	FfmpegPath string `yaml:"ffmpeg_path"`
+
+	Queue struct {
+		// DefaultLength is the max queue length of guilds that haven't configured it.
+		DefaultLength int `yaml:"default_length"`
+		// MaxLength is the max queue length guilds can configure.
+		MaxLength int `yaml:"max_length"`
+	} `yaml:"queue"`
+
+	Log struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+
+	// Guilds is the allowlist of guild IDs, all guilds are allowed if it is empty.
+	Guilds []string `yaml:"guilds"`
+
+	Playback struct {
+		// PreBuffer is the audio buffered before speaking and after underruns.
+		PreBuffer time.Duration `yaml:"prebuffer"`
+	} `yaml:"playback"`
+
+	Timeouts struct {
+		Fetch time.Duration `yaml:"fetch"`
+		// DownloadStart and Stall limit the time without audio at the start and in the middle of downloads.
+		DownloadStart time.Duration `yaml:"download_start"`
+		Stall         time.Duration `yaml:"stall"`
+		Command       time.Duration `yaml:"command"`
+		Shutdown      time.Duration `yaml:"shutdown"`
+	} `yaml:"timeouts"`
+
+	// Retries of network failures and timeouts of yt-dlp and ffmpeg.
+	Retries struct {
+		Count   int           `yaml:"count"`
+		Backoff time.Duration `yaml:"backoff"`
+	} `yaml:"retries"`
+
+	SettingsPath  string `yaml:"settings_path"`
+	SnapshotDir   string `yaml:"snapshot_dir"`
+	YtDlpCacheDir string `yaml:"ytdlp_cache_dir"`
+
+	// MetadataCache keeps the fetched track metadata, so tracks are queued again without yt-dlp.
+	MetadataCache struct {
+		// Size is the max number of cached tracks, the cache is disabled if it is 0.
+		Size int           `yaml:"size"`
+		TTL  time.Duration `yaml:"ttl"`
+		// Dir keeps the cache between restarts, the cache is kept in memory only if it is empty.
+		Dir string `yaml:"dir"`
+	} `yaml:"metadata_cache"`
+
+	// AudioCache keeps the downloaded audio of tracks, it is disabled if the directory is empty.
+	AudioCache struct {
+		Dir string `yaml:"dir"`
+		// MaxSizeMB is the total size of the cached files in megabytes.
+		MaxSizeMB int64 `yaml:"max_size_mb"`
+	} `yaml:"audio_cache"`
+
+	// VoiceDumpDir makes the players write the audio into Ogg Opus files in the directory
+	// instead of sending it to voice channels.
+	VoiceDumpDir string `yaml:"voice_dump_dir"`
+
+	// Library is the directory of music played with /disco local, it is disabled if the directory is empty.
+	Library struct {
+		Dir         string `yaml:"dir"`
+		FfprobePath string `yaml:"ffprobe_path"`
+		// RescanInterval is how often new files are indexed, the directory is scanned only at start if it is 0.
+		RescanInterval time.Duration `yaml:"rescan_interval"`
+	} `yaml:"library"`
+
+	// HTTPAddr is the address of the HTTP server exposing /metrics, the server is disabled if it is empty.
+	HTTPAddr string `yaml:"http_addr"`
+
+	// Admin is the API for controlling the players, it is disabled if the address is empty.
+	Admin struct {
+		Addr  string `yaml:"addr"`
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+}
+
+func defaultConfig() Config {
+	var cfg Config
+	cfg.YtDlpPath = ytdlp.DefaultYtDlpPath
+	cfg.FfmpegPath = ytdlp.DefaultFfmpegPath
+	cfg.Queue.DefaultLength = settings.Default().MaxQueueLength
+	cfg.Queue.MaxLength = settings.MaxQueueLengthCap
+	cfg.Library.FfprobePath = library.DefaultFfprobePath
+	cfg.Library.RescanInterval = 10 * time.Minute
+	cfg.Playback.PreBuffer = discobot.DefaultPreBuffer
+	cfg.MetadataCache.Size = 1000
+	cfg.MetadataCache.TTL = ytdlp.DefaultMetadataCacheTTL
+	cfg.AudioCache.MaxSizeMB = 1024
+	cfg.Log.Level = "info"
+	cfg.Log.Format = "text"
+	cfg.Timeouts.Fetch = time.Minute
+	cfg.Timeouts.DownloadStart = 30 * time.Second
+	cfg.Timeouts.Stall = 30 * time.Second
+	cfg.Timeouts.Command = discobot.DefaultCommandTimeout
+	cfg.Retries.Count = ytdlp.DefaultRetries
+	cfg.Retries.Backoff = ytdlp.DefaultRetryBackoff
+	cfg.Timeouts.Shutdown = 10 * time.Second
+	return cfg
+}
+
+type configOption struct {
+	flag, env, usage string
+	set              func(cfg *Config, value string) error
+}
+
+var configOptions = []configOption{
+	{"token", "TOKEN", "Discord bot token", func(cfg *Config, v string) error {
+		cfg.Token = v
+		return nil
+	}},
+	{"ytdlp-path", "YTDLP_PATH", "path to the yt-dlp binary", func(cfg *Config, v string) error {
+		cfg.YtDlpPath = v
+		return nil
+	}},
+	{"ffmpeg-path", "FFMPEG_PATH", "path to the ffmpeg binary", func(cfg *Config, v string) error {
+		cfg.FfmpegPath = v
+		return nil
+	}},
+	{"queue-default-length", "QUEUE_DEFAULT_LENGTH", "max queue length of guilds that haven't configured it", func(cfg *Config, v string) (err error) {
+		cfg.Queue.DefaultLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"queue-max-length", "QUEUE_MAX_LENGTH", "max queue length guilds can configure", func(cfg *Config, v string) (err error) {
+		cfg.Queue.MaxLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", func(cfg *Config, v string) error {
+		cfg.Log.Level = v
+		return nil
+	}},
+	{"log-format", "LOG_FORMAT", "log format: text or json", func(cfg *Config, v string) error {
+		cfg.Log.Format = v
+		return nil
+	}},
+	{"guilds", "GUILDS", "comma separated allowlist of guild IDs", func(cfg *Config, v string) error {
+		cfg.Guilds = nil
+		for _, guildID := range strings.Split(v, ",") {
+			if guildID = strings.TrimSpace(guildID); guildID != "" {
+				cfg.Guilds = append(cfg.Guilds, guildID)
+			}
+		}
+		return nil
+	}},
+	{"prebuffer", "PREBUFFER", "audio buffered before speaking and after underruns", func(cfg *Config, v string) (err error) {
+		cfg.Playback.PreBuffer, err = time.ParseDuration(v)
+		return err
+	}},
+	{"fetch-timeout", "FETCH_TIMEOUT", "timeout of fetching track metadata", func(cfg *Config, v string) (err error) {
+		cfg.Timeouts.Fetch, err = time.ParseDuration(v)
+		return err
+	}},
+	{"download-start-timeout", "DOWNLOAD_START_TIMEOUT", "timeout of the first audio of downloads", func(cfg *Config, v string) (err error) {
+		cfg.Timeouts.DownloadStart, err = time.ParseDuration(v)
+		return err
+	}},
+	{"stall-timeout", "STALL_TIMEOUT", "timeout of downloads without new audio", func(cfg *Config, v string) (err error) {
+		cfg.Timeouts.Stall, err = time.ParseDuration(v)
+		return err
+	}},
+	{"command-timeout", "COMMAND_TIMEOUT", "timeout of handling a command", func(cfg *Config, v string) (err error) {
+		cfg.Timeouts.Command, err = time.ParseDuration(v)
+		return err
+	}},
+	{"retries", "RETRIES", "retries of network failures and timeouts of yt-dlp and ffmpeg", func(cfg *Config, v string) (err error) {
+		cfg.Retries.Count, err = strconv.Atoi(v)
+		return err
+	}},
+	{"retry-backoff", "RETRY_BACKOFF", "delay before the first retry, doubled for the next ones", func(cfg *Config, v string) (err error) {
+		cfg.Retries.Backoff, err = time.ParseDuration(v)
+		return err
+	}},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "timeout of graceful shutdown", func(cfg *Config, v string) (err error) {
+		cfg.Timeouts.Shutdown, err = time.ParseDuration(v)
+		return err
+	}},
+	{"settings-path", "SETTINGS_PATH", "guild settings file, .json or .db", func(cfg *Config, v string) error {
+		cfg.SettingsPath = v
+		return nil
+	}},
+	{"snapshot-dir", "SNAPSHOT_DIR", "directory for play queue snapshots", func(cfg *Config, v string) error {
+		cfg.SnapshotDir = v
+		return nil
+	}},
+	{"ytdlp-cache-dir", "YTDLP_CACHE_DIR", "cache directory of yt-dlp", func(cfg *Config, v string) error {
+		cfg.YtDlpCacheDir = v
+		return nil
+	}},
+	{"metadata-cache-size", "METADATA_CACHE_SIZE", "max number of cached track metadata, 0 disables the cache", func(cfg *Config, v string) (err error) {
+		cfg.MetadataCache.Size, err = strconv.Atoi(v)
+		return err
+	}},
+	{"metadata-cache-ttl", "METADATA_CACHE_TTL", "how long track metadata is cached", func(cfg *Config, v string) (err error) {
+		cfg.MetadataCache.TTL, err = time.ParseDuration(v)
+		return err
+	}},
+	{"metadata-cache-dir", "METADATA_CACHE_DIR", "directory keeping the track metadata cache between restarts", func(cfg *Config, v string) error {
+		cfg.MetadataCache.Dir = v
+		return nil
+	}},
+	{"audio-cache-dir", "AUDIO_CACHE_DIR", "directory of the downloaded audio cache", func(cfg *Config, v string) error {
+		cfg.AudioCache.Dir = v
+		return nil
+	}},
+	{"audio-cache-max-size-mb", "AUDIO_CACHE_MAX_SIZE_MB", "total size of the downloaded audio cache in megabytes", func(cfg *Config, v string) (err error) {
+		cfg.AudioCache.MaxSizeMB, err = strconv.ParseInt(v, 10, 64)
+		return err
+	}},
+	{"voice-dump-dir", "VOICE_DUMP_DIR", "write the audio into Ogg Opus files in the directory instead of voice channels", func(cfg *Config, v string) error {
+		cfg.VoiceDumpDir = v
+		return nil
+	}},
+	{"library-dir", "LIBRARY_DIR", "directory of the music library", func(cfg *Config, v string) error {
+		cfg.Library.Dir = v
+		return nil
+	}},
+	{"ffprobe-path", "FFPROBE_PATH", "path to the ffprobe binary used by the music library", func(cfg *Config, v string) error {
+		cfg.Library.FfprobePath = v
+		return nil
+	}},
+	{"library-rescan-interval", "LIBRARY_RESCAN_INTERVAL", "interval of indexing new files of the music library, 0 disables rescans", func(cfg *Config, v string) (err error) {
+		cfg.Library.RescanInterval, err = time.ParseDuration(v)
+		return err
+	}},
+	{"http-addr", "HTTP_ADDR", "address of the metrics HTTP server, e.g. :9090", func(cfg *Config, v string) error {
+		cfg.HTTPAddr = v
+		return nil
+	}},
+	{"admin-addr", "ADMIN_ADDR", "address of the admin API, e.g. 127.0.0.1:8081", func(cfg *Config, v string) error {
+		cfg.Admin.Addr = v
+		return nil
+	}},
+	{"admin-token", "ADMIN_TOKEN", "bearer token of the admin API", func(cfg *Config, v string) error {
+		cfg.Admin.Token = v
+		return nil
+	}},
+}
+
+// loadConfig loads the config from the file, environment variables and command line arguments.
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	configPath := fs.String("config", os.Getenv("CONFIG"), "path to the YAML config file (env CONFIG)")
+
+	flagValues := make(map[string]string)
+	for _, opt := range configOptions {
+		opt := opt
+		fs.Func(opt.flag, fmt.Sprintf("%s (env %s)", opt.usage, opt.env), func(v string) error {
+			flagValues[opt.flag] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := defaultConfig()
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read the config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse the config file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, opt := range configOptions {
+		if v, ok := os.LookupEnv(opt.env); ok {
+			if err := opt.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", opt.env, err)
+			}
+		}
+	}
+
+	for _, opt := range configOptions {
+		if v, ok := flagValues[opt.flag]; ok {
+			if err := opt.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("invalid -%s: %w", opt.flag, err)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.Token == "" {
+		errs = append(errs, errors.New("token is not set: use -token, TOKEN or token in the config file"))
+	}
+	if cfg.Queue.MaxLength < 1 || cfg.Queue.MaxLength > settings.MaxQueueLengthCap {
+		errs = append(errs, fmt.Errorf("max queue length must be between 1 and %d", settings.MaxQueueLengthCap))
+	}
+	if cfg.Queue.DefaultLength < 1 || cfg.Queue.DefaultLength > cfg.Queue.MaxLength {
+		errs = append(errs, fmt.Errorf("default queue length must be between 1 and the max queue length %d", cfg.Queue.MaxLength))
+	}
+	if _, err := cfg.logLevel(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("invalid log format %q: must be text or json", cfg.Log.Format))
+	}
+	if _, err := cfg.guildIDs(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Playback.PreBuffer < 0 || cfg.Playback.PreBuffer > maxPreBuffer {
+		errs = append(errs, fmt.Errorf("pre-buffer must be between 0 and %s", maxPreBuffer))
+	}
+	if cfg.Timeouts.Fetch < 0 {
+		errs = append(errs, errors.New("fetch timeout can't be negative"))
+	}
+	if cfg.Timeouts.DownloadStart < 0 || cfg.Timeouts.Stall < 0 {
+		errs = append(errs, errors.New("download timeouts can't be negative"))
+	}
+	if cfg.Timeouts.Command <= 0 {
+		errs = append(errs, errors.New("command timeout must be positive"))
+	}
+	if cfg.Retries.Count < 0 {
+		errs = append(errs, errors.New("retries can't be negative"))
+	}
+	if cfg.Retries.Backoff <= 0 {
+		errs = append(errs, errors.New("retry backoff must be positive"))
+	}
+	if cfg.Timeouts.Shutdown <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if cfg.MetadataCache.Size < 0 {
+		errs = append(errs, errors.New("metadata cache size can't be negative"))
+	}
+	if cfg.MetadataCache.TTL <= 0 {
+		errs = append(errs, errors.New("metadata cache TTL must be positive"))
+	}
+	if cfg.AudioCache.Dir != "" && cfg.AudioCache.MaxSizeMB <= 0 {
+		errs = append(errs, errors.New("audio cache max size must be positive"))
+	}
+	if cfg.Library.RescanInterval < 0 {
+		errs = append(errs, errors.New("library rescan interval can't be negative"))
+	}
+	if cfg.Admin.Addr != "" && len(cfg.Admin.Token) < minAdminTokenLength {
+		errs = append(errs, fmt.Errorf("admin token must be at least %d characters: use -admin-token, ADMIN_TOKEN or admin.token in the config file", minAdminTokenLength))
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkBinaries checks that yt-dlp, ffmpeg and ffprobe of the music library can be found.
+func (cfg *Config) checkBinaries() error {
+	var errs []error
+	if _, err := exec.LookPath(cfg.YtDlpPath); err != nil {
+		errs = append(errs, fmt.Errorf("yt-dlp is not found, install it or set -ytdlp-path: %w", err))
+	}
+	if _, err := exec.LookPath(cfg.FfmpegPath); err != nil {
+		errs = append(errs, fmt.Errorf("ffmpeg is not found, install it or set -ffmpeg-path: %w", err))
+	}
+	if cfg.Library.Dir != "" {
+		if _, err := exec.LookPath(cfg.Library.FfprobePath); err != nil {
+			errs = append(errs, fmt.Errorf("ffprobe is not found, install it or set -ffprobe-path: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		return level, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", cfg.Log.Level)
+	}
+	return level, nil
+}
+
+func (cfg *Config) guildIDs() ([]dg.Snowflake, error) {
+	guildIDs := make([]dg.Snowflake, 0, len(cfg.Guilds))
+	for _, guild := range cfg.Guilds {
+		guildID, err := strconv.ParseUint(guild, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid guild ID %q", guild)
+		}
+		guildIDs = append(guildIDs, dg.Snowflake(guildID))
+	}
+	return guildIDs, nil
+}
+
+func (cfg *Config) logger() *slog.Logger {
+	level, _ := cfg.logLevel()
+	opts := &slog.HandlerOptions{Level: level}
+
+	if cfg.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+func (cfg *Config) settingsDefaults() settings.Guild {
+	defaults := settings.Default()
+	defaults.MaxQueueLength = cfg.Queue.DefaultLength
+	return defaults
+}
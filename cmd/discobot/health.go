@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"discobot"
+	"discobot/ytdlp"
+)
+
+const (
+	// binariesCheckInterval limits how often the readiness check runs yt-dlp and ffmpeg.
+	binariesCheckInterval = time.Minute
+	binariesCheckTimeout  = 10 * time.Second
+)
+
+// health adds the check of yt-dlp and ffmpeg to the readiness of the bot.
+type health struct {
+	bot   *discobot.DiscoBot
+	ytdlp *ytdlp.Client
+
+	mu          sync.Mutex
+	checkedAt   time.Time
+	binariesErr error
+}
+
+func (h *health) Healthy() error {
+	return h.bot.Healthy()
+}
+
+func (h *health) Ready() error {
+	return errors.Join(h.bot.Ready(), h.checkBinaries())
+}
+
+func (h *health) checkBinaries() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checkedAt) >= binariesCheckInterval {
+		_, h.binariesErr = selfCheck(h.ytdlp)
+		h.checkedAt = time.Now()
+	}
+	return h.binariesErr
+}
+
+// selfCheck checks that yt-dlp and ffmpeg run and returns their versions.
+func selfCheck(client *ytdlp.Client) (ytdlp.Versions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), binariesCheckTimeout)
+	defer cancel()
+
+	versions, err := client.Versions(ctx)
+	if err != nil {
+		return versions, fmt.Errorf("self-check failed: %w", err)
+	}
+	return versions, nil
+}
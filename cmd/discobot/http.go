@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/exp/slog"
+)
+
+// healthChecker reports the state of the bot, nil means it is fine.
+type healthChecker interface {
+	Healthy() error
+	Ready() error
+}
+
+type httpServer struct {
+	server *http.Server
+	logger *slog.Logger
+	// cancel cancels the contexts of the requests, so streaming responses end on shutdown.
+	cancel context.CancelFunc
+}
+
+// newMetricsHandler serves the metrics and the health checks.
+func newMetricsHandler(health healthChecker) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", checkHandler(health.Healthy))
+	mux.Handle("/readyz", checkHandler(health.Ready))
+	return mux
+}
+
+func newHTTPServer(addr string, handler http.Handler, logger *slog.Logger) *httpServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &httpServer{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+		},
+		logger: logger,
+		cancel: cancel,
+	}
+}
+
+// checkHandler responds with 200 if the check passes and 503 with the error otherwise.
+func checkHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// Start serves the requests in the background.
+func (s *httpServer) Start() {
+	go func() {
+		s.logger.Info("serving HTTP", "addr", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server failed", "err", err)
+		}
+	}()
+}
+
+func (s *httpServer) Shutdown(ctx context.Context) error {
+	s.cancel()
+	return s.server.Shutdown(ctx)
+}
@@ -2,37 +2,162 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"discobot"
-)
+	"discobot/admin"
+	"discobot/audiocache"
+	"discobot/library"
+	"discobot/settings"
+	"discobot/ytdlp"
 
-var token = os.Getenv("TOKEN")
+	"golang.org/x/exp/slog"
+)
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	if len(os.Args) > 1 && os.Args[1] == "commands" {
+		if err := runCommands(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err == nil {
+		err = cfg.checkBinaries()
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	logger := cfg.logger()
+	slog.SetDefault(logger)
+	discobot.SetLogger(logger)
+
+	guildIDs, _ := cfg.guildIDs()
 
-	bot := discobot.NewDiscoBot(token)
-	if err := bot.Open(ctx); err != nil {
+	var store settings.Store = settings.NewMemoryStore(cfg.settingsDefaults())
+	if cfg.SettingsPath != "" {
+		if store, err = settings.Open(cfg.SettingsPath, cfg.settingsDefaults()); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	defer store.Close()
+
+	ytdlpClient := ytdlp.New(ytdlp.Config{
+		YtDlpPath:            cfg.YtDlpPath,
+		FfmpegPath:           cfg.FfmpegPath,
+		CacheDir:             cfg.YtDlpCacheDir,
+		FetchTimeout:         cfg.Timeouts.Fetch,
+		DownloadStartTimeout: cfg.Timeouts.DownloadStart,
+		StallTimeout:         cfg.Timeouts.Stall,
+		Retries:              cfg.Retries.Count,
+		RetryBackoff:         cfg.Retries.Backoff,
+		MetadataCache: ytdlp.MetadataCacheConfig{
+			Size: cfg.MetadataCache.Size,
+			TTL:  cfg.MetadataCache.TTL,
+			Dir:  cfg.MetadataCache.Dir,
+		},
+		Logger: logger,
+	})
+	versions, err := selfCheck(ytdlpClient)
+	if err != nil {
 		log.Fatalln(err)
 	}
-	defer bot.Close()
+	logger.Info("found yt-dlp and ffmpeg", "ytdlp_version", versions.YtDlp, "ffmpeg_version", versions.Ffmpeg)
 
-	go func() {
-		sc := make(chan os.Signal, 1)
-		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	libraryCtx, stopLibrary := context.WithCancel(context.Background())
+	defer stopLibrary()
+
+	opts := []discobot.Option{
+		discobot.WithSettingsStore(store),
+		discobot.WithMaxQueueLength(cfg.Queue.MaxLength),
+		discobot.WithPreBuffer(cfg.Playback.PreBuffer),
+		discobot.WithFfmpegPath(cfg.FfmpegPath),
+		discobot.WithCommandTimeout(cfg.Timeouts.Command),
+		discobot.WithYtDlp(ytdlpClient),
+	}
+	if len(guildIDs) != 0 {
+		opts = append(opts, discobot.WithGuildAllowlist(guildIDs...))
+	}
+	if cfg.SnapshotDir != "" {
+		opts = append(opts, discobot.WithSnapshotDir(cfg.SnapshotDir))
+	}
+	if cfg.AudioCache.Dir != "" {
+		cache, err := audiocache.New(audiocache.Config{
+			Dir:     cfg.AudioCache.Dir,
+			MaxSize: cfg.AudioCache.MaxSizeMB << 20,
+			Logger:  logger.With("component", "audio_cache"),
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts = append(opts, discobot.WithAudioCache(cache))
+	}
+	if cfg.Library.Dir != "" {
+		lib := library.New(library.Config{
+			Dir:         cfg.Library.Dir,
+			FfprobePath: cfg.Library.FfprobePath,
+			FfmpegPath:  cfg.FfmpegPath,
+			Logger:      logger.With("component", "library"),
+		})
+		go lib.Run(libraryCtx, cfg.Library.RescanInterval)
+		opts = append(opts, discobot.WithLibrary(lib))
+	}
+	if cfg.VoiceDumpDir != "" {
+		opts = append(opts, discobot.WithVoiceConnector(discobot.OggFileConnector{Dir: cfg.VoiceDumpDir}))
+	}
+
+	bot := discobot.NewDiscoBot(cfg.Token, opts...)
+
+	var servers []*httpServer
+	if cfg.HTTPAddr != "" {
+		health := &health{bot: bot, ytdlp: ytdlpClient, checkedAt: time.Now()}
+		servers = append(servers, newHTTPServer(cfg.HTTPAddr, newMetricsHandler(health), logger))
+	}
+	if cfg.Admin.Addr != "" {
+		adminLogger := logger.With("component", "admin")
+		servers = append(servers, newHTTPServer(cfg.Admin.Addr, admin.NewHandler(bot, cfg.Admin.Token, adminLogger), adminLogger))
+	}
+	for _, srv := range servers {
+		srv.Start()
+	}
 
-		fmt.Println("Press CTRL-C to exit.")
+	if err := bot.Open(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
 
-		<-sc
-		cancel()
+	playerErr := make(chan error, 1)
+	go func() {
+		playerErr <- bot.RunPlayer(context.Background())
 	}()
 
-	if err := bot.RunPlayer(ctx); err != nil {
-		log.Println(err)
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+
+	fmt.Println("Press CTRL-C to exit.")
+
+	select {
+	case <-sc:
+	case err := <-playerErr:
+		logger.Error("players stopped", "err", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+	defer cancel()
+
+	if err := bot.Shutdown(ctx); err != nil {
+		logger.Error("failed to shut down gracefully", "err", err)
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("failed to shut down the HTTP server", "err", err)
+		}
 	}
 }
@@ -0,0 +1,266 @@
+package discobot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	dg "github.com/andersfylling/disgord"
+	"golang.org/x/exp/slices"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+var commandRouter = newRouter(
+	newGroup("disco", "play music",
+		newCommand("play", "add a track to the play queue", (*DiscoBot).handleDisco).withDeferredReply(),
+		newCommand("local", "add a track from the music library", (*DiscoBot).handleLocal).withAutocomplete((*DiscoBot).autocompleteLocal),
+		newCommand("pause", "pause", (*DiscoBot).handlePause),
+		newCommand("resume", "unpause", (*DiscoBot).handlePlay),
+		newCommand("skip", "skip the current track", (*DiscoBot).handleSkip),
+		newCommand("voteskip", "vote to skip the current track", (*DiscoBot).handleVoteSkip),
+		newGroup("queue", "manage the play queue",
+			newCommand("list", "show the play queue", (*DiscoBot).handleQueueList),
+			newCommand("clear", "clean the play queue", (*DiscoBot).handleClean),
+		),
+		newGroup("config", "configure the bot for the server",
+			newCommand("show", "show the current settings", (*DiscoBot).handleConfigShow),
+			newCommand("volume", "set the volume", (*DiscoBot).handleConfigVolume).withPermission(djOnly),
+			newCommand("dj-role", "set the DJ role", (*DiscoBot).handleConfigDJRole).withPermission(djOnly),
+			newCommand("loop", "set the loop mode", (*DiscoBot).handleConfigLoop).withPermission(djOnly),
+			newCommand("max-queue", "set the max length of the play queue", (*DiscoBot).handleConfigMaxQueue).withPermission(djOnly),
+			newCommand("announcements", "set the channel for now playing announcements", (*DiscoBot).handleConfigAnnouncements).withPermission(djOnly),
+			newCommand("vote-skip", "set the share of listeners needed to skip a track", (*DiscoBot).handleConfigVoteSkip).withPermission(djOnly),
+			newCommand("crossfade", "set the crossfade between tracks", (*DiscoBot).handleConfigCrossfade).withPermission(djOnly),
+		),
+	),
+)
+
+// legacyCommands are the flat commands replaced by the /disco subcommands.
+// They are removed by the overwrite of the registered commands.
+var legacyCommands = []string{
+	"disco-play",
+	"disco-pause",
+	"disco-skip",
+	"disco-voteskip",
+	"disco-clean",
+	"disco-config",
+}
+
+// CommandRegistry registers the slash commands of the bot. Commands are compared
+// with the registered ones and overwritten only if they differ.
+type CommandRegistry struct {
+	token    string
+	client   *http.Client
+	commands []*applicationCommand
+
+	appIDMu sync.Mutex
+	appID   dg.Snowflake
+}
+
+func NewCommandRegistry(token string) *CommandRegistry {
+	if !strings.HasPrefix(token, "Bot ") {
+		token = "Bot " + token
+	}
+
+	return &CommandRegistry{
+		token:    token,
+		client:   http.DefaultClient,
+		commands: commandRouter.applicationCommands(),
+	}
+}
+
+// Register registers the commands in the guild or globally if guildID is zero.
+// It returns true if the registered commands are changed.
+func (r *CommandRegistry) Register(ctx context.Context, guildID dg.Snowflake) (bool, error) {
+	return r.sync(ctx, guildID, r.commands)
+}
+
+// Unregister removes all commands of the bot from the guild or globally if guildID is zero.
+// It returns true if there were registered commands.
+func (r *CommandRegistry) Unregister(ctx context.Context, guildID dg.Snowflake) (bool, error) {
+	return r.sync(ctx, guildID, []*applicationCommand{})
+}
+
+func (r *CommandRegistry) sync(ctx context.Context, guildID dg.Snowflake, desired []*applicationCommand) (bool, error) {
+	appID, err := r.applicationID(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	endpoint := fmt.Sprintf("/applications/%s/commands", appID)
+	if !guildID.IsZero() {
+		endpoint = fmt.Sprintf("/applications/%s/guilds/%s/commands", appID, guildID)
+	}
+
+	var registered []*registeredCommand
+	if err := r.do(ctx, http.MethodGet, endpoint, nil, &registered); err != nil {
+		return false, fmt.Errorf("failed to list commands: %w", err)
+	}
+
+	if commandsEqual(desired, registered, guildID.IsZero()) {
+		return false, nil
+	}
+
+	for _, c := range registered {
+		if slices.Contains(legacyCommands, c.Name) {
+			logger.Info("removing the legacy command", "command", c.Name, "guild", guildID)
+		}
+	}
+
+	if err := r.do(ctx, http.MethodPut, endpoint, desired, nil); err != nil {
+		return false, fmt.Errorf("failed to overwrite commands: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *CommandRegistry) applicationID(ctx context.Context) (dg.Snowflake, error) {
+	r.appIDMu.Lock()
+	defer r.appIDMu.Unlock()
+
+	if !r.appID.IsZero() {
+		return r.appID, nil
+	}
+
+	var app dg.Application
+	if err := r.do(ctx, http.MethodGet, "/oauth2/applications/@me", nil, &app); err != nil {
+		return 0, fmt.Errorf("failed to get the application: %w", err)
+	}
+	r.appID = app.ID
+
+	return r.appID, nil
+}
+
+func (r *CommandRegistry) do(ctx context.Context, method, endpoint string, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, discordAPI+endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", r.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, msg)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// registeredCommand is the registered command with the fields disgord doesn't have.
+type registeredCommand struct {
+	dg.ApplicationCommand
+	// DMPermission is nil if it's the default, the commands are allowed in DMs then.
+	DMPermission *bool `json:"dm_permission"`
+}
+
+// commandSpec is a comparable form of a command, it ignores fields set by Discord.
+type commandSpec struct {
+	Name         string
+	Description  string
+	Type         dg.ApplicationCommandType
+	Options      []optionSpec
+	DMPermission bool
+}
+
+type optionSpec struct {
+	Type         dg.OptionType
+	Name         string
+	Description  string
+	Required     bool
+	Choices      []string
+	Options      []optionSpec
+	ChannelTypes []dg.ChannelType
+	MinValue     float64
+	MaxValue     float64
+	Autocomplete bool
+}
+
+// commandsEqual compares the commands, the DM permission only applies to global commands.
+func commandsEqual(desired []*applicationCommand, registered []*registeredCommand, global bool) bool {
+	desiredSpecs := make(map[string]commandSpec, len(desired))
+	for _, c := range desired {
+		spec := newCommandSpec(c.Name, c.Description, c.Type, c.Options)
+		spec.DMPermission = global && c.DMPermission
+		desiredSpecs[c.Name] = spec
+	}
+
+	registeredSpecs := make(map[string]commandSpec, len(registered))
+	for _, c := range registered {
+		spec := newCommandSpec(c.Name, c.Description, c.Type, c.Options)
+		spec.DMPermission = global && (c.DMPermission == nil || *c.DMPermission)
+		registeredSpecs[c.Name] = spec
+	}
+
+	return reflect.DeepEqual(desiredSpecs, registeredSpecs)
+}
+
+func newCommandSpec(name, description string, typ dg.ApplicationCommandType, options []*dg.ApplicationCommandOption) commandSpec {
+	if typ == 0 {
+		typ = dg.ApplicationCommandChatInput
+	}
+	return commandSpec{
+		Name:        name,
+		Description: description,
+		Type:        typ,
+		Options:     newOptionSpecs(options),
+	}
+}
+
+func newOptionSpecs(options []*dg.ApplicationCommandOption) []optionSpec {
+	if len(options) == 0 {
+		return nil
+	}
+
+	specs := make([]optionSpec, len(options))
+	for i, o := range options {
+		spec := optionSpec{
+			Type:         o.Type,
+			Name:         o.Name,
+			Description:  o.Description,
+			Required:     o.Required,
+			Options:      newOptionSpecs(o.Options),
+			Autocomplete: o.Autocomplete,
+		}
+		for _, choice := range o.Choices {
+			spec.Choices = append(spec.Choices, fmt.Sprintf("%s=%v", choice.Name, choice.Value))
+		}
+		if len(o.ChannelTypes) != 0 {
+			spec.ChannelTypes = o.ChannelTypes
+		}
+		if o.Type == dg.OptionTypeInteger || o.Type == dg.OptionTypeNumber {
+			spec.MinValue = o.MinValue
+			spec.MaxValue = o.MaxValue
+		}
+		specs[i] = spec
+	}
+
+	return specs
+}
@@ -0,0 +1,215 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"discobot/settings"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// ErrUnknownGuild is returned for guilds the bot is not connected to or not allowed in.
+var ErrUnknownGuild = errors.New("unknown guild")
+
+// PlayerStatus is the state of the player of a guild.
+type PlayerStatus struct {
+	GuildID dg.Snowflake      `json:"guild_id"`
+	Status  string            `json:"status"`
+	Current *TrackStatus      `json:"current,omitempty"`
+	Queue   []TrackStatus     `json:"queue"`
+	Volume  int               `json:"volume"`
+	Loop    settings.LoopMode `json:"loop"`
+}
+
+type TrackStatus struct {
+	Title       string       `json:"title"`
+	URL         string       `json:"url"`
+	ChannelID   dg.Snowflake `json:"channel_id"`
+	RequesterID dg.Snowflake `json:"requester_id,omitempty"`
+	// Duration is the length of the track in seconds, it is 0 for live streams.
+	Duration float64 `json:"duration,omitempty"`
+	// Elapsed is the position of the current track in seconds.
+	Elapsed float64 `json:"elapsed,omitempty"`
+}
+
+func newTrackStatus(task *Task) TrackStatus {
+	return TrackStatus{
+		Title:       task.track.Title,
+		URL:         task.track.URL,
+		ChannelID:   task.channelID,
+		RequesterID: task.requesterID,
+		Duration:    task.track.Duration.Seconds(),
+	}
+}
+
+func (s PlayStatus) String() string {
+	switch s {
+	case PlayingPlayStatus:
+		return "playing"
+	case PausedPlayStatus:
+		return "paused"
+	default:
+		return "idle"
+	}
+}
+
+// Guilds returns the allowed guilds the bot is connected to.
+func (bot *DiscoBot) Guilds() []dg.Snowflake {
+	var guildIDs []dg.Snowflake
+	for _, guildID := range bot.client.GetConnectedGuilds() {
+		if bot.guildAllowed(guildID) {
+			guildIDs = append(guildIDs, guildID)
+		}
+	}
+	sort.Slice(guildIDs, func(i, j int) bool { return guildIDs[i] < guildIDs[j] })
+	return guildIDs
+}
+
+// checkGuild returns ErrUnknownGuild if the bot is not connected to the guild.
+func (bot *DiscoBot) checkGuild(guildID dg.Snowflake) error {
+	for _, id := range bot.Guilds() {
+		if id == guildID {
+			return nil
+		}
+	}
+	return ErrUnknownGuild
+}
+
+// existingPlayer returns the player of the guild without creating it, nil is returned
+// if nothing was played in the guild.
+func (bot *DiscoBot) existingPlayer(guildID dg.Snowflake) *Player {
+	bot.playersMu.Lock()
+	defer bot.playersMu.Unlock()
+	return bot.players[guildID]
+}
+
+// PlayerStatus returns the current track and the queue of the guild,
+// the guild without a player is idle.
+func (bot *DiscoBot) PlayerStatus(guildID dg.Snowflake) (PlayerStatus, error) {
+	if err := bot.checkGuild(guildID); err != nil {
+		return PlayerStatus{}, err
+	}
+	guildSettings, err := bot.settings.Get(guildID)
+	if err != nil {
+		return PlayerStatus{}, err
+	}
+
+	status := PlayerStatus{
+		GuildID: guildID,
+		Status:  IdlePlayStatus.String(),
+		Queue:   []TrackStatus{},
+		Volume:  guildSettings.Volume,
+		Loop:    guildSettings.LoopMode,
+	}
+	player := bot.existingPlayer(guildID)
+	if player == nil {
+		return status, nil
+	}
+	status.Status = player.playback.Status().String()
+	if task := player.currentTask.Load(); task != nil {
+		current := newTrackStatus(task)
+		current.Elapsed = player.Elapsed().Seconds()
+		status.Current = &current
+	}
+	for _, task := range player.queue.Items() {
+		status.Queue = append(status.Queue, newTrackStatus(task))
+	}
+
+	return status, nil
+}
+
+// Enqueue fetches the track and adds it to the queue of the guild, it is played in the voice channel.
+func (bot *DiscoBot) Enqueue(ctx context.Context, guildID, channelID dg.Snowflake, url string) error {
+	if err := bot.checkGuild(guildID); err != nil {
+		return err
+	}
+	_, err := bot.queueTrack(ctx, guildID, channelID, 0, 0, ytdlpSourceName, url)
+	return err
+}
+
+func (bot *DiscoBot) Pause(guildID dg.Snowflake) error {
+	return bot.withPlayer(guildID, func(p *Player) { p.playback.Pause() })
+}
+
+func (bot *DiscoBot) Resume(guildID dg.Snowflake) error {
+	return bot.withPlayer(guildID, func(p *Player) { p.playback.Resume() })
+}
+
+func (bot *DiscoBot) Skip(guildID dg.Snowflake) error {
+	return bot.withPlayer(guildID, func(p *Player) { p.playback.Skip() })
+}
+
+// Stop removes all queued tracks and skips the current one.
+func (bot *DiscoBot) Stop(guildID dg.Snowflake) error {
+	return bot.withPlayer(guildID, (*Player).Clean)
+}
+
+// ClearQueue removes all queued tracks, the current one keeps playing.
+func (bot *DiscoBot) ClearQueue(guildID dg.Snowflake) error {
+	return bot.withPlayer(guildID, (*Player).ClearQueue)
+}
+
+// MoveTrack moves the queued track from one position to another, positions start from 0.
+func (bot *DiscoBot) MoveTrack(guildID dg.Snowflake, from, to int) error {
+	if err := bot.checkGuild(guildID); err != nil {
+		return err
+	}
+	player := bot.existingPlayer(guildID)
+	if player == nil {
+		return ErrQueuePosition
+	}
+	return player.MoveTrack(from, to)
+}
+
+// SetVolume sets the volume of the guild in percents, it applies from the next track.
+func (bot *DiscoBot) SetVolume(guildID dg.Snowflake, volume int) error {
+	if err := bot.checkGuild(guildID); err != nil {
+		return err
+	}
+	_, err := bot.updateSettings(guildID, func(s *settings.Guild) {
+		s.Volume = volume
+	})
+	return err
+}
+
+// withPlayer calls f with the player of the guild, nothing is done if the guild has no player.
+func (bot *DiscoBot) withPlayer(guildID dg.Snowflake, f func(*Player)) error {
+	if err := bot.checkGuild(guildID); err != nil {
+		return err
+	}
+	if player := bot.existingPlayer(guildID); player != nil {
+		f(player)
+	}
+	return nil
+}
+
+// updateSettings applies the update to the guild settings, validates and saves them.
+// Updates are serialized, so concurrent updates of the commands and the admin API aren't lost.
+func (bot *DiscoBot) updateSettings(guildID dg.Snowflake, update func(s *settings.Guild)) (settings.Guild, error) {
+	bot.settingsMu.Lock()
+	defer bot.settingsMu.Unlock()
+
+	guildSettings, err := bot.settings.Get(guildID)
+	if err != nil {
+		return guildSettings, err
+	}
+
+	update(&guildSettings)
+	if err := guildSettings.Validate(); err != nil {
+		return guildSettings, &userError{err: err}
+	}
+	if err := bot.settings.Put(guildID, guildSettings); err != nil {
+		return guildSettings, err
+	}
+	logger.Info("guild settings are updated", "guild", guildID, "settings", guildSettings)
+
+	// new players are created with the capacity of the settings
+	if player := bot.existingPlayer(guildID); player != nil {
+		player.queue.SetCapacity(bot.queueCapacity(guildSettings))
+		player.changed()
+	}
+
+	return guildSettings, nil
+}
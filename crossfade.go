@@ -0,0 +1,183 @@
+package discobot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"discobot/ogg/opus"
+	"discobot/subprocess"
+
+	"golang.org/x/exp/slog"
+)
+
+// crossfadeKillDelay is the time given to ffmpeg to exit after the interrupt.
+const crossfadeKillDelay = 5 * time.Second
+
+// crossfader mixes the end of a track with the beginning of the next one by the acrossfade filter of ffmpeg.
+type crossfader struct {
+	ffmpegPath string
+}
+
+// crossfade sends the tail of the previous track mixed with the beginning of the packets followed
+// by the rest of the packets. If ffmpeg fails, the tail is played as is before the packets.
+func (c crossfader) crossfade(ctx context.Context, log *slog.Logger, tail [][]byte, packets <-chan []byte, out chan<- []byte) {
+	head := make([][]byte, 0, len(tail))
+	closed := false
+	for !closed && len(head) < len(tail) {
+		select {
+		case <-ctx.Done():
+			return
+		case packet, ok := <-packets:
+			if !ok {
+				closed = true
+				break
+			}
+			head = append(head, packet)
+		}
+	}
+
+	start := time.Now()
+	mixed, err := c.mix(ctx, tail, head)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("failed to crossfade the tracks", "err", err)
+		mixed = append(tail, head...)
+	}
+	log.Debug("crossfaded the tracks", "frames", len(mixed), "took", time.Since(start))
+
+	for _, packet := range mixed {
+		select {
+		case <-ctx.Done():
+			return
+		case out <- packet:
+		}
+	}
+	if closed {
+		return
+	}
+	for packet := range packets {
+		select {
+		case <-ctx.Done():
+			return
+		case out <- packet:
+		}
+	}
+}
+
+// mix runs ffmpeg with the tail on stdin and the head on the 3rd file descriptor,
+// the crossfade lasts for the shorter of them.
+func (c crossfader) mix(ctx context.Context, tail, head [][]byte) ([][]byte, error) {
+	if len(head) == 0 {
+		return tail, nil
+	}
+
+	tailOgg, err := writeOgg(tail)
+	if err != nil {
+		return nil, err
+	}
+	headOgg, err := writeOgg(head)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, c.ffmpegPath,
+		"-i", "pipe:0",
+		"-i", "pipe:3",
+		"-filter_complex", acrossfadeFilter(len(tail), len(head)),
+		"-acodec", "libopus",
+		"-f", "ogg",
+		"pipe:1",
+	)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = crossfadeKillDelay
+
+	headReader, headWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer headReader.Close()
+	cmd.ExtraFiles = []*os.File{headReader}
+	cmd.Stdin = bytes.NewReader(tailOgg)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		headWriter.Close()
+		return nil, err
+	}
+	// the pipe is written concurrently, so ffmpeg isn't blocked on its buffer
+	go func() {
+		_, _ = headWriter.Write(headOgg)
+		headWriter.Close()
+	}()
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, subprocess.LastLine(stderr.Bytes()))
+	}
+
+	return readOgg(&stdout)
+}
+
+// acrossfadeFilter returns the filter mixing the frames of the tail with the frames of the head,
+// the crossfade lasts for the shorter of them.
+func acrossfadeFilter(tail, head int) string {
+	frames := tail
+	if head < frames {
+		frames = head
+	}
+	duration := time.Duration(frames) * frameDuration
+	return "[0:a][1:a]acrossfade=d=" + strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+func writeOgg(packets [][]byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := opus.NewWriter(&b, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, packet := range packets {
+		if err := w.WritePacket(packet); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func readOgg(r io.Reader) ([][]byte, error) {
+	d, err := opus.NewOpusDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	var packets [][]byte
+	for {
+		packetReader, err := d.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return packets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		packet, err := io.ReadAll(packetReader)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, packet)
+	}
+}
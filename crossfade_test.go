@@ -0,0 +1,153 @@
+package discobot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"discobot/settings"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestCrossfadeFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		crossfade int
+		duration  time.Duration
+		start     time.Duration
+		seekable  bool
+		want      int
+	}{
+		{name: "disabled", crossfade: 0, duration: time.Minute, want: 0},
+		{name: "unknown duration", crossfade: 3, duration: 0, want: 0},
+		{name: "track of two crossfades", crossfade: 3, duration: 6 * time.Second, want: 150},
+		{name: "track shorter than two crossfades", crossfade: 3, duration: 6*time.Second - frameDuration, want: 0},
+		{name: "track shorter than the crossfade", crossfade: 5, duration: 2 * time.Second, want: 0},
+		{name: "rest after the start is too short", crossfade: 3, duration: time.Minute, start: 55 * time.Second, seekable: true, want: 0},
+		{name: "start of unseekable track is ignored", crossfade: 3, duration: time.Minute, start: 55 * time.Second, want: 150},
+		{name: "max crossfade", crossfade: settings.MaxCrossfade, duration: time.Minute, want: 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{
+				track: &Track{Duration: tt.duration, Seekable: tt.seekable},
+				start: tt.start,
+			}
+			if got := crossfadeFrames(task, settings.Guild{Crossfade: tt.crossfade}); got != tt.want {
+				t.Errorf("got %d frames, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcrossfadeFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		tail, head int
+		want       string
+	}{
+		{name: "equal", tail: 150, head: 150, want: "[0:a][1:a]acrossfade=d=3.000"},
+		{name: "short next track", tail: 150, head: 10, want: "[0:a][1:a]acrossfade=d=0.200"},
+		{name: "short tail", tail: 1, head: 150, want: "[0:a][1:a]acrossfade=d=0.020"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acrossfadeFilter(tt.tail, tt.head); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeOpusToChanHoldsBackTheTail(t *testing.T) {
+	tests := []struct {
+		name     string
+		frames   int
+		holdBack int
+		sent     int
+	}{
+		{name: "no crossfade", frames: 5, holdBack: 0, sent: 5},
+		{name: "longer track", frames: 5, holdBack: 2, sent: 3},
+		{name: "track of the tail", frames: 2, holdBack: 2, sent: 0},
+		{name: "track shorter than the tail", frames: 1, holdBack: 2, sent: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var frames [][]byte
+			for i := 0; i < tt.frames; i++ {
+				frames = append(frames, testFrame(i))
+			}
+			data, err := writeOgg(frames)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan []byte, tt.frames)
+			tail, err := decodeOpusToChan(context.Background(), bytes.NewReader(data), ch, tt.holdBack)
+			if err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+
+			var sent [][]byte
+			for packet := range ch {
+				sent = append(sent, packet)
+			}
+			if len(sent) != tt.sent {
+				t.Fatalf("got %d sent frames, want %d", len(sent), tt.sent)
+			}
+			// the sent frames and the tail are the track in order
+			if got := append(sent, tail...); !reflect.DeepEqual(got, frames) {
+				t.Errorf("got frames %v and tail %v, want %v", sent, tail, frames)
+			}
+		})
+	}
+}
+
+func TestCrossfadeFallsBackWithoutFfmpeg(t *testing.T) {
+	tests := []struct {
+		name string
+		head int
+		rest int
+	}{
+		{name: "next track", head: 3, rest: 2},
+		{name: "next track shorter than the tail", head: 1},
+		{name: "empty next track"},
+	}
+
+	tail := [][]byte{{0xFC, 0xF0}, {0xFC, 0xF1}, {0xFC, 0xF2}}
+	mixer := crossfader{ffmpegPath: "/nonexistent/ffmpeg"}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want [][]byte
+			want = append(want, tail...)
+			packets := make(chan []byte, tt.head+tt.rest)
+			for i := 0; i < tt.head+tt.rest; i++ {
+				packets <- testFrame(i)
+				want = append(want, testFrame(i))
+			}
+			close(packets)
+
+			out := make(chan []byte, len(want))
+			mixer.crossfade(context.Background(), log, tail, packets, out)
+			close(out)
+
+			var got [][]byte
+			for packet := range out {
+				got = append(got, packet)
+			}
+			// the tail is played as is before the next track
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -1,45 +1,132 @@
 package discobot
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"discobot/audiocache"
+	"discobot/library"
+	"discobot/metrics"
 	"discobot/ogg/opus"
+	"discobot/settings"
+	"discobot/webm"
 	"discobot/ytdlp"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	dg "github.com/andersfylling/disgord"
 	"golang.org/x/exp/slog"
-	"golang.org/x/sync/errgroup"
 )
 
 var logger = slog.Default()
 
+const registerTimeout = 30 * time.Second
+
+// DefaultCommandTimeout limits the time of handling a command if it isn't configured.
+const DefaultCommandTimeout = 2 * time.Minute
+
+// SetLogger sets the logger of the package.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
 type DiscoBot struct {
-	client            *dg.Client
-	playback          Playback
-	playQueue         Queue[*Task]
-	channelIDByUserID map[dg.Snowflake]dg.Snowflake
+	client   *dg.Client
+	voice    VoiceConnector
+	commands *CommandRegistry
+	// sources are the sources of tracks by name, URLs are resolved with yt-dlp.
+	sources map[string]Source
+	// library is nil if the music library is not configured.
+	library *library.Library
+	// audioCache is nil if the audio of yt-dlp tracks isn't cached.
+	audioCache *audiocache.Cache
+	settings   settings.Store
+	// settingsMu serializes the updates of the settings.
+	settingsMu sync.Mutex
+	snapshots  *snapshotStore
+
+	// allowedGuilds is nil if all guilds are allowed.
+	allowedGuilds  map[dg.Snowflake]bool
+	maxQueueLength int
+	prebuffer      time.Duration
+	ffmpegPath     string
+	// commandTimeout limits the time of handling a command, e.g. resolving the track.
+	commandTimeout time.Duration
+
+	playersMu   sync.Mutex
+	players     map[dg.Snowflake]*Player
+	playersCtx  context.Context
+	stopPlayers context.CancelFunc
+	playersWG   sync.WaitGroup
+	events      playerEvents
+
+	closing atomic.Bool
+	// gatewayReady and commandsRegistered are reported by the health checks.
+	gatewayReady       atomic.Bool
+	commandsRegistered atomic.Bool
+	// cleanedGuilds are the guilds the guild commands are removed from, once per process.
+	cleanedGuilds sync.Map
+
+	voiceStatesMu sync.Mutex
+	voiceStates   map[dg.Snowflake]voiceState
+	djRoles       djRoles
 }
 
 type Task struct {
-	video              *ytdlp.FetchResult
+	track              *Track
 	guildID, channelID dg.Snowflake
+	requesterID        dg.Snowflake
+	// textChannelID is the channel where the track was requested.
+	textChannelID dg.Snowflake
+	// start is a position to start playing from, it is set for resumed tracks.
+	start time.Duration
+	// resumes is the number of times the track was resumed after failures.
+	resumes int
 }
 
-func NewDiscoBot(token string) *DiscoBot {
+// startPosition returns the position to start playing from, it is 0 for tracks which aren't seekable.
+func (t *Task) startPosition() time.Duration {
+	if !t.track.Seekable {
+		return 0
+	}
+	return t.start
+}
+
+type voiceState struct {
+	channelID dg.Snowflake
+	bot       bool
+}
+
+func NewDiscoBot(token string, opts ...Option) *DiscoBot {
 	client := dg.New(dg.Config{
 		BotToken: token,
 		Intents:  dg.IntentGuilds | dg.IntentGuildMessages | dg.IntentGuildVoiceStates,
 	})
 
 	bot := &DiscoBot{
-		client:            client,
-		playback:          NewPlayback(),
-		playQueue:         NewQueue[*Task](32),
-		channelIDByUserID: make(map[dg.Snowflake]dg.Snowflake),
+		client:         client,
+		voice:          DisgordConnector{Client: client},
+		commands:       NewCommandRegistry(token),
+		sources:        map[string]Source{ytdlpSourceName: ytdlpSource{client: ytdlp.New(ytdlp.Config{})}},
+		settings:       settings.NewMemoryStore(settings.Default()),
+		maxQueueLength: settings.MaxQueueLengthCap,
+		prebuffer:      DefaultPreBuffer,
+		ffmpegPath:     ytdlp.DefaultFfmpegPath,
+		commandTimeout: DefaultCommandTimeout,
+		players:        make(map[dg.Snowflake]*Player),
+		voiceStates:    make(map[dg.Snowflake]voiceState),
+	}
+	for _, opt := range opts {
+		opt(bot)
+	}
+	if source, ok := bot.sources[ytdlpSourceName].(ytdlpSource); ok && bot.audioCache != nil {
+		source.cache = bot.audioCache
+		bot.sources[ytdlpSourceName] = source
 	}
 
 	gateway := client.Gateway()
@@ -47,281 +134,661 @@ func NewDiscoBot(token string) *DiscoBot {
 	gateway.InteractionCreate(bot.handleInteractionCreate)
 	gateway.BotReady(func() {
 		logger.Info("bot is ready")
+		bot.gatewayReady.Store(true)
+		bot.registerCommands()
 	})
 
 	gateway.VoiceStateUpdate(func(s dg.Session, h *dg.VoiceStateUpdate) {
-		if channelID := h.VoiceState.ChannelID; !channelID.IsZero() {
-			bot.channelIDByUserID[h.VoiceState.UserID] = h.VoiceState.ChannelID
-		} else {
-			delete(bot.channelIDByUserID, h.VoiceState.UserID)
-		}
+		bot.updateVoiceState(h.VoiceState)
+	})
+	gateway.GuildRoleCreate(func(s dg.Session, h *dg.GuildRoleCreate) {
+		bot.djRoles.update(h.GuildID, h.Role)
+	})
+	gateway.GuildRoleUpdate(func(s dg.Session, h *dg.GuildRoleUpdate) {
+		bot.djRoles.update(h.GuildID, h.Role)
+	})
+	gateway.GuildRoleDelete(func(s dg.Session, h *dg.GuildRoleDelete) {
+		bot.djRoles.remove(h.GuildID, h.RoleID)
 	})
 
 	return bot
 }
 
+func (bot *DiscoBot) updateVoiceState(vs *dg.VoiceState) {
+	bot.voiceStatesMu.Lock()
+	defer bot.voiceStatesMu.Unlock()
+
+	if vs.ChannelID.IsZero() {
+		delete(bot.voiceStates, vs.UserID)
+		return
+	}
+
+	state := bot.voiceStates[vs.UserID]
+	state.channelID = vs.ChannelID
+	if vs.Member != nil && vs.Member.User != nil {
+		state.bot = vs.Member.User.Bot
+	}
+	bot.voiceStates[vs.UserID] = state
+}
+
+func (bot *DiscoBot) userChannelID(userID dg.Snowflake) (dg.Snowflake, bool) {
+	bot.voiceStatesMu.Lock()
+	defer bot.voiceStatesMu.Unlock()
+
+	state, found := bot.voiceStates[userID]
+	return state.channelID, found
+}
+
+// listenersCount returns the number of users except bots in the voice channel.
+func (bot *DiscoBot) listenersCount(channelID dg.Snowflake) int {
+	bot.voiceStatesMu.Lock()
+	defer bot.voiceStatesMu.Unlock()
+
+	listeners := 0
+	for _, state := range bot.voiceStates {
+		if state.channelID == channelID && !state.bot {
+			listeners++
+		}
+	}
+	return listeners
+}
+
 func (bot *DiscoBot) Open(ctx context.Context) error {
 	return bot.client.Gateway().WithContext(ctx).Connect()
 }
 
 func (bot *DiscoBot) Close() error {
+	bot.gatewayReady.Store(false)
 	return bot.client.Gateway().Disconnect()
 }
 
-func (bot *DiscoBot) queueTrack(ctx context.Context, guildID, channelID dg.Snowflake, url string) error {
-	video, err := ytdlp.Fetch(ctx, url)
-	if err != nil {
-		return err
+// Shutdown stops the bot: new commands are rejected, guilds are notified, players are stopped
+// and their voice connections are closed, then the gateway is disconnected.
+// Shutdown doesn't wait for the players longer than the context allows.
+func (bot *DiscoBot) Shutdown(ctx context.Context) error {
+	if bot.closing.Swap(true) {
+		return nil
 	}
 
-	if err := bot.playQueue.Push(&Task{
-		video:     video,
-		guildID:   guildID,
-		channelID: channelID,
-	}); err != nil {
-		return err
+	bot.notifyShutdown(ctx)
+	bot.SaveSnapshots()
+
+	bot.playersMu.Lock()
+	if bot.stopPlayers != nil {
+		bot.stopPlayers()
 	}
+	bot.playersMu.Unlock()
 
-	return nil
+	stopped := make(chan struct{})
+	go func() {
+		bot.playersWG.Wait()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = fmt.Errorf("players are not stopped: %w", ctx.Err())
+	}
+
+	return errors.Join(err, bot.Close())
 }
 
-func (bot *DiscoBot) RunPlayer(ctx context.Context) error {
-	var voice dg.VoiceConnection
-	for {
-		task, err := bot.playQueue.Pop(ctx)
-		if err != nil {
-			return err
-		}
+// notifyShutdown posts a message to the guilds where something is playing.
+func (bot *DiscoBot) notifyShutdown(ctx context.Context) {
+	content := "The bot is shutting down, the play queue is lost"
+	if bot.snapshots != nil {
+		content = "The bot is restarting, playback will be resumed shortly"
+	}
 
-		if voice == nil {
-			// Join the provided voice channel.
-			voice, err = bot.client.Guild(task.guildID).VoiceChannel(task.channelID).Connect(false, true)
-			if err != nil {
-				return err
-			}
+	bot.playersMu.Lock()
+	players := make([]*Player, 0, len(bot.players))
+	for _, player := range bot.players {
+		players = append(players, player)
+	}
+	bot.playersMu.Unlock()
+
+	for _, player := range players {
+		task := player.currentTask.Load()
+		if task == nil {
+			continue
 		}
 
-		if err := bot.play(ctx, voice, task); err != nil {
-			logger.Error("", err)
+		channelID := task.textChannelID
+		if guildSettings, err := bot.settings.Get(player.guildID); err == nil && !guildSettings.AnnouncementChannelID.IsZero() {
+			channelID = guildSettings.AnnouncementChannelID
+		}
+		if channelID.IsZero() {
+			continue
 		}
 
-		if bot.playQueue.Len() == 0 {
-			voice.Close()
-			voice = nil
+		_, err := bot.client.Channel(channelID).WithContext(ctx).CreateMessage(&dg.CreateMessage{Content: content})
+		if err != nil {
+			logger.Error("failed to notify about shutdown", "guild", player.guildID, "err", err)
 		}
 	}
 }
 
-func (bot *DiscoBot) play(ctx context.Context, voice dg.VoiceConnection, task *Task) error {
-	bot.playback.StartCurrentTrack()
-	defer bot.playback.FinishCurrentTrack()
+// queueTrack resolves the query with the source and adds the track to the queue of the guild.
+func (bot *DiscoBot) queueTrack(ctx context.Context, guildID, channelID, textChannelID, requesterID dg.Snowflake, sourceName, query string) (*Track, error) {
+	player, err := bot.player(guildID)
+	if err != nil {
+		return nil, err
+	}
 
-	packetChan := make(chan []byte, 2048)
+	source, err := bot.source(sourceName)
+	if err != nil {
+		return nil, err
+	}
+	track, err := source.Resolve(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	r, w, err := os.Pipe()
+	if err := player.Enqueue(&Task{
+		track:         track,
+		guildID:       guildID,
+		channelID:     channelID,
+		requesterID:   requesterID,
+		textChannelID: textChannelID,
+	}); err != nil {
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// player returns the player of the guild, the player is created and started on the first call.
+func (bot *DiscoBot) player(guildID dg.Snowflake) (*Player, error) {
+	bot.playersMu.Lock()
+	defer bot.playersMu.Unlock()
+
+	if player, ok := bot.players[guildID]; ok {
+		return player, nil
+	}
+
+	guildSettings, err := bot.settings.Get(guildID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.Go(func() error {
-		defer logger.Info("player stopped")
+	player := newPlayer(bot.client, bot.voice, bot.sources, bot.settings, bot.snapshots, guildID, bot.queueCapacity(guildSettings), bot.prebuffer, bot.ffmpegPath, bot.events.publish)
+	bot.players[guildID] = player
+	if bot.playersCtx != nil {
+		bot.startPlayer(player)
+	}
 
-		voice.StartSpeaking()
-		defer voice.StopSpeaking()
+	return player, nil
+}
 
-		for packet := range packetChan {
-			if err := bot.playback.Check(ctx); err != nil {
-				return err
-			}
-			if err := voice.SendOpusFrame(packet); err != nil {
-				return err
-			}
-		}
+func (bot *DiscoBot) queueCapacity(guildSettings settings.Guild) int {
+	if guildSettings.MaxQueueLength > bot.maxQueueLength {
+		return bot.maxQueueLength
+	}
+	return guildSettings.MaxQueueLength
+}
 
+func (bot *DiscoBot) guildAllowed(guildID dg.Snowflake) bool {
+	return bot.allowedGuilds == nil || bot.allowedGuilds[guildID]
+}
+
+// restorePlayer creates the player of the guild from its snapshot.
+// It does nothing if the player already exists or there is no snapshot.
+func (bot *DiscoBot) restorePlayer(guildID dg.Snowflake) error {
+	if bot.snapshots == nil {
 		return nil
-	})
-	eg.Go(func() error {
-		defer func() {
-			w.Close()
-			logger.Info("downloader stopped")
-		}()
-		if err := task.video.Download(ctx, w); err != nil {
-			return err
-		}
+	}
 
+	bot.playersMu.Lock()
+	_, exists := bot.players[guildID]
+	bot.playersMu.Unlock()
+	if exists {
 		return nil
-	})
-	eg.Go(func() error {
-		defer func() {
-			close(packetChan)
-			r.Close()
-			logger.Info("decoder stopped")
-		}()
-
-		return decodeOpusToChan(ctx, r, packetChan)
-	})
+	}
+
+	snapshot, found, err := bot.snapshots.load(guildID)
+	if err != nil || !found {
+		return err
+	}
+
+	player, err := bot.player(guildID)
+	if err != nil {
+		return err
+	}
+	player.restore(snapshot)
 
-	return eg.Wait()
+	return nil
 }
 
-func (bot *DiscoBot) guildCreate(s dg.Session, event *dg.GuildCreate) {
-	voiceStates := event.Guild.VoiceStates
-	for _, vs := range voiceStates {
-		userID := vs.UserID
-		bot.channelIDByUserID[userID] = vs.ChannelID
-	}
-
-	var commands = []*dg.CreateApplicationCommand{
-		{Name: "disco", Description: "play music", Options: []*dg.ApplicationCommandOption{
-			{
-				Type:        dg.OptionTypeString,
-				Name:        "url",
-				Description: "YouTube video URL",
-				Required:    true,
-			},
-		}},
-		{Name: "disco-play", Description: "unpause"},
-		{Name: "disco-pause", Description: "pause"},
-		{Name: "disco-skip", Description: "skip the current track"},
-		{Name: "disco-clean", Description: "clean the play queue"},
-	}
-
-	for i := range commands {
-		if err := bot.client.ApplicationCommand(0).Guild(event.Guild.ID).Create(commands[i]); err != nil {
-			log.Fatal(err)
+// SaveSnapshots saves the state of all players, so it can be resumed after restart.
+func (bot *DiscoBot) SaveSnapshots() {
+	bot.playersMu.Lock()
+	defer bot.playersMu.Unlock()
+
+	for _, player := range bot.players {
+		player.saveSnapshot()
+	}
+}
+
+// startPlayer must be called with playersMu held.
+func (bot *DiscoBot) startPlayer(player *Player) {
+	bot.playersWG.Add(1)
+	player.running.Store(true)
+	go func() {
+		defer bot.playersWG.Done()
+		defer player.running.Store(false)
+		if err := player.RunPlayer(bot.playersCtx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("player stopped", "guild", player.guildID, "err", err)
 		}
+	}()
+}
+
+// RunPlayer runs the players of all guilds until the context is done or the bot is shut down.
+func (bot *DiscoBot) RunPlayer(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bot.playersMu.Lock()
+	bot.playersCtx = ctx
+	bot.stopPlayers = cancel
+	for _, player := range bot.players {
+		bot.startPlayer(player)
 	}
+	bot.playersMu.Unlock()
+
+	<-ctx.Done()
+	bot.playersWG.Wait()
+
+	return ctx.Err()
 }
 
-func (bot *DiscoBot) handleInteractionCreate(s dg.Session, i *dg.InteractionCreate) {
-	var err error
+func (bot *DiscoBot) guildCreate(s dg.Session, event *dg.GuildCreate) {
+	if !bot.guildAllowed(event.Guild.ID) {
+		logger.Warn("guild is not allowed", "guild", event.Guild.ID)
+		return
+	}
 
-	switch i.Data.Name {
-	case "disco":
-		err = bot.handleDisco(s, i)
-	case "disco-play":
-		err = bot.handlePlay(s, i)
-	case "disco-pause":
-		err = bot.handlePause(s, i)
-	case "disco-skip":
-		err = bot.handleSkip(s, i)
-	case "disco-clean":
-		err = bot.handleClean(s, i)
+	botUsers := make(map[dg.Snowflake]bool)
+	for _, member := range event.Guild.Members {
+		if member.User != nil && member.User.Bot {
+			botUsers[member.User.ID] = true
+		}
 	}
 
-	if err != nil {
-		logger.Error("", err)
+	bot.voiceStatesMu.Lock()
+	for _, vs := range event.Guild.VoiceStates {
+		bot.voiceStates[vs.UserID] = voiceState{
+			channelID: vs.ChannelID,
+			bot:       botUsers[vs.UserID],
+		}
+	}
+	bot.voiceStatesMu.Unlock()
+	bot.djRoles.set(event.Guild.ID, event.Guild.Roles)
+
+	if err := bot.restorePlayer(event.Guild.ID); err != nil {
+		logger.Error("failed to restore the player", "guild", event.Guild.ID, "err", err)
+	}
+
+	// Commands are registered globally, so the ones left in the guild are removed.
+	// GuildCreate is received again on reconnects, the guild is checked only the first time.
+	if bot.allowedGuilds == nil && bot.startGuildCleanup(event.Guild.ID) {
+		ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+		defer cancel()
+
+		if changed, err := bot.commands.Unregister(ctx, event.Guild.ID); err != nil {
+			logger.Error("failed to remove guild commands", "guild", event.Guild.ID, "err", err)
+			bot.cleanedGuilds.Delete(event.Guild.ID)
+		} else if changed {
+			logger.Info("guild commands are removed", "guild", event.Guild.ID)
+		}
 	}
 }
 
-func (bot *DiscoBot) handleDisco(s dg.Session, i *dg.InteractionCreate) error {
-	if i.Type != dg.InteractionApplicationCommand {
-		return nil
+// startGuildCleanup reports whether the guild commands of the guild aren't removed yet in this process.
+func (bot *DiscoBot) startGuildCleanup(guildID dg.Snowflake) bool {
+	_, cleaned := bot.cleanedGuilds.LoadOrStore(guildID, struct{}{})
+	return !cleaned
+}
+
+// registerCommands registers the commands in the allowed guilds or globally if all guilds are allowed.
+// The global commands left from running without the allowlist are removed.
+func (bot *DiscoBot) registerCommands() {
+	ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+	defer cancel()
+
+	guildIDs := []dg.Snowflake{0}
+	registered := true
+	if bot.allowedGuilds != nil {
+		guildIDs = guildIDs[:0]
+		for guildID := range bot.allowedGuilds {
+			guildIDs = append(guildIDs, guildID)
+		}
+
+		if changed, err := bot.commands.Unregister(ctx, 0); err != nil {
+			logger.Error("failed to remove global commands", "err", err)
+			registered = false
+		} else if changed {
+			logger.Info("global commands are removed")
+		}
 	}
 
-	url := i.Data.Options[0].Value.(string)
+	for _, guildID := range guildIDs {
+		changed, err := bot.commands.Register(ctx, guildID)
+		if err != nil {
+			logger.Error("failed to register commands", "guild", guildID, "err", err)
+			registered = false
+			continue
+		}
+		if changed {
+			logger.Info("commands are registered", "guild", guildID)
+		}
+	}
+	bot.commandsRegistered.Store(registered)
+}
 
-	channelID, found := bot.channelIDByUserID[i.Member.UserID]
-	if !found {
-		return fmt.Errorf("user \"%s\" is not in the voice channel", i.Member.Nick)
+func (bot *DiscoBot) handleInteractionCreate(s dg.Session, i *dg.InteractionCreate) {
+	if !bot.guildAllowed(i.GuildID) {
+		return
 	}
-	if err := bot.queueTrack(context.Background(), i.GuildID, channelID, url); err != nil {
-		_ = s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
+	if bot.closing.Load() {
+		if i.Type == dg.InteractionApplicationCommandAutocomplete {
+			return
+		}
+		err := s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
 			Type: dg.InteractionCallbackChannelMessageWithSource,
-			Data: &dg.CreateInteractionResponseData{Content: err.Error()},
+			Data: &dg.CreateInteractionResponseData{Content: "The bot is shutting down", Flags: dg.MessageFlagEphemeral},
 		})
-		return fmt.Errorf("error playing sound: %w", err)
+		if err != nil {
+			logger.Error("failed to reply", "guild", i.GuildID, "err", err)
+		}
+		return
 	}
 
-	if err := s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
-		Type: dg.InteractionCallbackChannelMessageWithSource,
-		Data: &dg.CreateInteractionResponseData{Content: fmt.Sprintf("Added %s to the play queue", url)},
-	}); err != nil {
-		return err
+	if i.Type == dg.InteractionApplicationCommand && i.Data != nil {
+		metrics.Commands.WithLabelValues(commandPath(i.Data)).Inc()
 	}
+	commandRouter.handle(bot, s, i)
+}
 
-	return nil
+type noOptions struct{}
+
+type discoOptions struct {
+	URL string `option:"url" description:"YouTube video URL" required:"true"`
 }
 
-func (bot *DiscoBot) handlePause(s dg.Session, i *dg.InteractionCreate) error {
-	if i.Type != dg.InteractionApplicationCommand {
-		return nil
+func (bot *DiscoBot) handleDisco(c *commandContext, opts discoOptions) error {
+	i := c.interaction
+
+	channelID, found := bot.userChannelID(i.Member.UserID)
+	if !found {
+		return errNotInVoice
+	}
+	if _, err := bot.queueTrack(c, i.GuildID, channelID, i.ChannelID, i.Member.UserID, ytdlpSourceName, opts.URL); err != nil {
+		return fmt.Errorf("error queueing %s: %w", opts.URL, err)
 	}
 
-	bot.playback.Pause()
+	return c.reply(fmt.Sprintf("Added %s to the play queue", opts.URL))
+}
 
-	return s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
-		Type: dg.InteractionCallbackChannelMessageWithSource,
-		Data: &dg.CreateInteractionResponseData{Content: "Paused..."},
-	})
+// maxChoices and maxChoiceLength are the Discord limits of autocomplete choices.
+const (
+	maxChoices      = 25
+	maxChoiceLength = 100
+)
+
+type localOptions struct {
+	Search string `option:"search" description:"title, artist or file name" required:"true" autocomplete:"true"`
 }
 
-func (bot *DiscoBot) handlePlay(s dg.Session, i *dg.InteractionCreate) error {
-	if i.Type != dg.InteractionApplicationCommand {
-		return nil
+func (bot *DiscoBot) handleLocal(c *commandContext, opts localOptions) error {
+	if bot.library == nil {
+		return errLibraryDisabled
 	}
+	i := c.interaction
 
-	bot.playback.Resume()
+	channelID, found := bot.userChannelID(i.Member.UserID)
+	if !found {
+		return errNotInVoice
+	}
+	track, err := bot.queueTrack(c, i.GuildID, channelID, i.ChannelID, i.Member.UserID, librarySourceName, opts.Search)
+	if err != nil {
+		return fmt.Errorf("error queueing %s: %w", opts.Search, err)
+	}
 
-	return s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
-		Type: dg.InteractionCallbackChannelMessageWithSource,
-		Data: &dg.CreateInteractionResponseData{Content: "Playing..."},
-	})
+	return c.reply(fmt.Sprintf("Added **%s** to the play queue", track.Title))
 }
 
-func (bot *DiscoBot) handleSkip(s dg.Session, i *dg.InteractionCreate) error {
-	if i.Type != dg.InteractionApplicationCommand {
-		return nil
+// autocompleteLocal suggests the files of the library matching the search,
+// the values are file IDs resolved by the library source.
+func (bot *DiscoBot) autocompleteLocal(_ *commandContext, search string) ([]*dg.ApplicationCommandOptionChoice, error) {
+	if bot.library == nil {
+		return nil, nil
 	}
 
-	bot.playback.Skip()
+	entries := bot.library.Search(search, maxChoices)
+	choices := make([]*dg.ApplicationCommandOptionChoice, len(entries))
+	for i, entry := range entries {
+		name := entry.Name()
+		if runes := []rune(name); len(runes) > maxChoiceLength {
+			name = string(runes[:maxChoiceLength-1]) + "…"
+		}
+		choices[i] = &dg.ApplicationCommandOptionChoice{Name: name, Value: entry.ID}
+	}
+	return choices, nil
+}
 
-	return s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
-		Type: dg.InteractionCallbackChannelMessageWithSource,
-		Data: &dg.CreateInteractionResponseData{Content: "Skip the current track"},
-	})
+func (bot *DiscoBot) handlePause(c *commandContext, _ noOptions) error {
+	if err := bot.Pause(c.interaction.GuildID); err != nil {
+		return err
+	}
+
+	return c.reply("Paused...")
 }
 
-func (bot *DiscoBot) handleClean(s dg.Session, i *dg.InteractionCreate) error {
-	if i.Type != dg.InteractionApplicationCommand {
-		return nil
+func (bot *DiscoBot) handlePlay(c *commandContext, _ noOptions) error {
+	if err := bot.Resume(c.interaction.GuildID); err != nil {
+		return err
 	}
 
-	bot.playQueue.Clean()
-	bot.playback.Skip()
+	return c.reply("Playing...")
+}
 
-	return s.SendInteractionResponse(context.Background(), i, &dg.CreateInteractionResponse{
-		Type: dg.InteractionCallbackChannelMessageWithSource,
-		Data: &dg.CreateInteractionResponseData{Content: "Clean the play queue"},
-	})
+func (bot *DiscoBot) handleSkip(c *commandContext, _ noOptions) error {
+	if err := bot.Skip(c.interaction.GuildID); err != nil {
+		return err
+	}
+
+	return c.reply("Skip the current track")
+}
+
+func (bot *DiscoBot) handleVoteSkip(c *commandContext, _ noOptions) error {
+	content, err := bot.voteSkip(c.interaction.GuildID, c.interaction.Member)
+	if err != nil {
+		return err
+	}
+
+	return c.reply(content)
+}
+
+// voteSkip registers a skip vote of the member and skips the current track
+// once enough listeners voted. The requester of the track and DJs skip it immediately.
+func (bot *DiscoBot) voteSkip(guildID dg.Snowflake, member *dg.Member) (string, error) {
+	player := bot.existingPlayer(guildID)
+	if player == nil {
+		return "Nothing is playing", nil
+	}
+
+	task := player.currentTask.Load()
+	if task == nil {
+		return "Nothing is playing", nil
+	}
+
+	guildSettings, err := bot.settings.Get(guildID)
+	if err != nil {
+		return "", err
+	}
+
+	isDJ, err := bot.isDJ(guildID, guildSettings, member)
+	if err != nil {
+		return "", err
+	}
+	if isDJ || member.UserID == task.requesterID {
+		player.playback.Skip()
+		return "Skip the current track", nil
+	}
+
+	if channelID, _ := bot.userChannelID(member.UserID); channelID != task.channelID {
+		return "You have to be in the voice channel to vote", nil
+	}
+
+	votes, ok := player.skipVotes.Vote(task, member.UserID)
+	if !ok {
+		return "Nothing is playing", nil
+	}
+
+	required := requiredVotes(bot.listenersCount(task.channelID), guildSettings.VoteSkipRatio)
+	if votes < required {
+		return fmt.Sprintf("Voted to skip the current track: %d/%d", votes, required), nil
+	}
+
+	player.playback.Skip()
+	return fmt.Sprintf("Skip the current track: %d/%d votes", votes, required), nil
 }
 
-func decodeOpusToChan(ctx context.Context, r io.Reader, ch chan<- []byte) error {
-	d, err := opus.NewOpusDecoder(r)
+// isDJ reports whether the member has the DJ role. If the DJ role is not configured
+// for the guild, the role named "DJ" is used.
+func (bot *DiscoBot) isDJ(guildID dg.Snowflake, guildSettings settings.Guild, member *dg.Member) (bool, error) {
+	if len(member.Roles) == 0 {
+		return false, nil
+	}
+
+	if !guildSettings.DJRoleID.IsZero() {
+		for _, roleID := range member.Roles {
+			if roleID == guildSettings.DJRoleID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if found, cached := bot.djRoles.has(guildID, member.Roles); cached {
+		return found, nil
+	}
+
+	// the roles are requested only if the GuildCreate event of the guild hasn't been received yet
+	roles, err := bot.client.Guild(guildID).GetRoles()
 	if err != nil {
+		return false, err
+	}
+	bot.djRoles.set(guildID, roles)
+
+	found, _ := bot.djRoles.has(guildID, member.Roles)
+	return found, nil
+}
+
+const queueListLimit = 10
+
+func (bot *DiscoBot) handleQueueList(c *commandContext, _ noOptions) error {
+	player := bot.existingPlayer(c.interaction.GuildID)
+	if player == nil {
+		return c.reply("Nothing is playing")
+	}
+
+	var b strings.Builder
+	if task := player.currentTask.Load(); task != nil {
+		fmt.Fprintf(&b, "Now playing: %s [%s]\n", task.track.Title, player.Elapsed().Truncate(time.Second))
+	} else {
+		b.WriteString("Nothing is playing\n")
+	}
+
+	tasks := player.queue.Items()
+	for i, task := range tasks {
+		if i == queueListLimit {
+			fmt.Fprintf(&b, "and %d more", len(tasks)-queueListLimit)
+			break
+		}
+		fmt.Fprintf(&b, "%d. %s\n", i+1, task.track.Title)
+	}
+
+	return c.reply(b.String())
+}
+
+func (bot *DiscoBot) handleClean(c *commandContext, _ noOptions) error {
+	if err := bot.Stop(c.interaction.GuildID); err != nil {
 		return err
 	}
+
+	return c.reply("Clean the play queue")
+}
+
+// decodeOpusToChan sends the packets of the stream to the channel. The last holdBack packets
+// aren't sent, they are returned at the end of the stream.
+func decodeOpusToChan(ctx context.Context, r io.Reader, ch chan<- []byte, holdBack int) ([][]byte, error) {
+	d, err := newPacketDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	var held [][]byte
 	for {
 		packetReader, err := d.NextPacket()
 		if errors.Is(err, io.EOF) {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		packet, err := io.ReadAll(packetReader)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		if holdBack > 0 {
+			held = append(held, packet)
+			if len(held) <= holdBack {
+				continue
+			}
+			packet, held = held[0], held[1:]
 		}
 
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		case ch <- packet:
 		}
 	}
 
-	return nil
+	return held, nil
+}
+
+// packetDecoder reads Opus packets from a container.
+type packetDecoder interface {
+	NextPacket() (io.Reader, error)
+}
+
+// newPacketDecoder detects the container of the stream, Ogg or WebM.
+func newPacketDecoder(r io.Reader) (packetDecoder, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("OggS")):
+		d, err := opus.NewOpusDecoder(br)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	case bytes.Equal(magic, webm.Magic):
+		d, err := webm.NewDecoder(br)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unknown container: %x", magic)
+	}
 }
@@ -0,0 +1,61 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+
+	"discobot/library"
+	"discobot/ytdlp"
+)
+
+var (
+	errNotInVoice      = errors.New("user is not in the voice channel")
+	errLibraryDisabled = errors.New("the music library is not configured")
+)
+
+// userErrors map the errors caused by the user input to the replies, the first match wins.
+var userErrors = []struct {
+	err     error
+	message string
+}{
+	{errNotInVoice, "Join a voice channel first"},
+	{ErrQueueFull, "The play queue is full, try again later"},
+	{ErrQueuePosition, "There is no track at this position in the queue"},
+	{ytdlp.ErrUnsupportedURL, "This link is not supported"},
+	{ytdlp.ErrGeoBlocked, "This video is not available in the bot's country"},
+	{ytdlp.ErrPrivateVideo, "This video is private"},
+	{ytdlp.ErrAgeRestricted, "This video is age-restricted"},
+	{ytdlp.ErrUnavailable, "This video is unavailable"},
+	{ytdlp.ErrTimeout, "Timed out loading the video, try again later"},
+	{ytdlp.ErrNetwork, "Failed to reach the video site, try again later"},
+	{errLibraryDisabled, "The music library is not configured"},
+	{library.ErrNotFound, "Nothing is found in the music library"},
+	{context.DeadlineExceeded, "Timed out, try again later"},
+}
+
+// userError is caused by the user input, its message is replied as is.
+type userError struct {
+	err error
+}
+
+func (e *userError) Error() string {
+	return e.err.Error()
+}
+
+func (e *userError) Unwrap() error {
+	return e.err
+}
+
+// UserMessage returns the reply for the error caused by the user input.
+func UserMessage(err error) (string, bool) {
+	var uErr *userError
+	if errors.As(err, &uErr) {
+		return uErr.Error(), true
+	}
+	for _, e := range userErrors {
+		if errors.Is(err, e.err) {
+			return e.message, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,45 @@
+package discobot
+
+import (
+	"sync"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// playerEvents notifies the subscribers about the changed players.
+type playerEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan dg.Snowflake]struct{}
+}
+
+func (e *playerEvents) publish(guildID dg.Snowflake) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- guildID:
+		default:
+			// Skip slow subscribers, they are expected to refresh periodically
+		}
+	}
+}
+
+// SubscribePlayers returns the channel receiving the IDs of guilds whose player is changed:
+// the playback, the current track, the queue or the settings. The returned function unsubscribes.
+func (bot *DiscoBot) SubscribePlayers() (<-chan dg.Snowflake, func()) {
+	ch := make(chan dg.Snowflake, 64)
+
+	bot.events.mu.Lock()
+	if bot.events.subscribers == nil {
+		bot.events.subscribers = make(map[chan dg.Snowflake]struct{})
+	}
+	bot.events.subscribers[ch] = struct{}{}
+	bot.events.mu.Unlock()
+
+	return ch, func() {
+		bot.events.mu.Lock()
+		defer bot.events.mu.Unlock()
+		delete(bot.events.subscribers, ch)
+	}
+}
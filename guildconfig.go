@@ -0,0 +1,160 @@
+package discobot
+
+import (
+	"discobot/settings"
+	"fmt"
+	"strings"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+type volumeOptions struct {
+	Percent int `option:"percent" description:"volume in percents" required:"true" min:"1" max:"200"`
+}
+
+type djRoleOptions struct {
+	Role dg.Snowflake `option:"role" description:"DJ role" required:"true" type:"role"`
+}
+
+type loopOptions struct {
+	Mode string `option:"mode" description:"loop mode" required:"true" choices:"off,track,queue"`
+}
+
+type maxQueueOptions struct {
+	Length int `option:"length" description:"max number of queued tracks" required:"true" min:"1" max:"1000"`
+}
+
+type announcementsOptions struct {
+	Channel dg.Snowflake `option:"channel" description:"text channel, omit to disable announcements" type:"text_channel"`
+}
+
+type voteSkipOptions struct {
+	Percent int `option:"percent" description:"share of listeners in percents" required:"true" min:"1" max:"100"`
+}
+
+type crossfadeOptions struct {
+	Seconds int `option:"seconds" description:"crossfade duration in seconds, 0 disables it" required:"true" min:"0" max:"12"`
+}
+
+func (bot *DiscoBot) handleConfigShow(c *commandContext, _ noOptions) error {
+	guildSettings, err := bot.settings.Get(c.interaction.GuildID)
+	if err != nil {
+		return err
+	}
+
+	return c.replyEphemeral(formatSettings(guildSettings))
+}
+
+func (bot *DiscoBot) handleConfigVolume(c *commandContext, opts volumeOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.Volume = opts.Percent
+		return fmt.Sprintf("Volume is set to %d%%, it applies from the next track", opts.Percent)
+	})
+}
+
+func (bot *DiscoBot) handleConfigDJRole(c *commandContext, opts djRoleOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.DJRoleID = opts.Role
+		return fmt.Sprintf("DJ role is set to <@&%s>", opts.Role)
+	})
+}
+
+func (bot *DiscoBot) handleConfigLoop(c *commandContext, opts loopOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.LoopMode = settings.LoopMode(opts.Mode)
+		return fmt.Sprintf("Loop mode is set to %s", opts.Mode)
+	})
+}
+
+func (bot *DiscoBot) handleConfigMaxQueue(c *commandContext, opts maxQueueOptions) error {
+	if opts.Length > bot.maxQueueLength {
+		return c.replyEphemeral(fmt.Sprintf("Max queue length can't be greater than %d", bot.maxQueueLength))
+	}
+
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.MaxQueueLength = opts.Length
+		return fmt.Sprintf("Max queue length is set to %d", opts.Length)
+	})
+}
+
+func (bot *DiscoBot) handleConfigAnnouncements(c *commandContext, opts announcementsOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.AnnouncementChannelID = opts.Channel
+		if opts.Channel.IsZero() {
+			return "Announcements are disabled"
+		}
+		return fmt.Sprintf("Tracks are announced in <#%s>", opts.Channel)
+	})
+}
+
+func (bot *DiscoBot) handleConfigVoteSkip(c *commandContext, opts voteSkipOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.VoteSkipRatio = float64(opts.Percent) / 100
+		return fmt.Sprintf("Skipping a track requires %d%% of listeners", opts.Percent)
+	})
+}
+
+func (bot *DiscoBot) handleConfigCrossfade(c *commandContext, opts crossfadeOptions) error {
+	return bot.updateConfig(c, func(s *settings.Guild) string {
+		s.Crossfade = opts.Seconds
+		if opts.Seconds == 0 {
+			return "Crossfade is disabled"
+		}
+		return fmt.Sprintf("Tracks are crossfaded for %d seconds, it applies from the next track", opts.Seconds)
+	})
+}
+
+// updateConfig applies the change to the guild settings, stores them and replies with the returned message.
+func (bot *DiscoBot) updateConfig(c *commandContext, update func(s *settings.Guild) string) error {
+	var content string
+	_, err := bot.updateSettings(c.interaction.GuildID, func(s *settings.Guild) {
+		content = update(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.replyEphemeral(content)
+}
+
+// isPrivileged reports whether the member is a DJ or the server owner.
+func (bot *DiscoBot) isPrivileged(guildID dg.Snowflake, member *dg.Member) (bool, error) {
+	guild, err := bot.client.Guild(guildID).Get()
+	if err != nil {
+		return false, err
+	}
+	if guild.OwnerID == member.UserID {
+		return true, nil
+	}
+
+	guildSettings, err := bot.settings.Get(guildID)
+	if err != nil {
+		return false, err
+	}
+
+	return bot.isDJ(guildID, guildSettings, member)
+}
+
+func formatSettings(s settings.Guild) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Volume: %d%%\n", s.Volume)
+	if s.DJRoleID.IsZero() {
+		b.WriteString("DJ role: DJ\n")
+	} else {
+		fmt.Fprintf(&b, "DJ role: <@&%s>\n", s.DJRoleID)
+	}
+	fmt.Fprintf(&b, "Loop mode: %s\n", s.LoopMode)
+	fmt.Fprintf(&b, "Max queue length: %d\n", s.MaxQueueLength)
+	if s.AnnouncementChannelID.IsZero() {
+		b.WriteString("Announcements: disabled\n")
+	} else {
+		fmt.Fprintf(&b, "Announcements: <#%s>\n", s.AnnouncementChannelID)
+	}
+	fmt.Fprintf(&b, "Vote skip: %.0f%% of listeners\n", s.VoteSkipRatio*100)
+	if s.Crossfade == 0 {
+		b.WriteString("Crossfade: disabled")
+	} else {
+		fmt.Fprintf(&b, "Crossfade: %ds", s.Crossfade)
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package discobot
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Healthy reports whether the gateway is connected and the players are running.
+func (bot *DiscoBot) Healthy() error {
+	if !bot.gatewayReady.Load() {
+		return errors.New("gateway is not connected")
+	}
+	if _, err := bot.client.HeartbeatLatencies(); err != nil {
+		return fmt.Errorf("gateway heartbeat: %w", err)
+	}
+
+	bot.playersMu.Lock()
+	defer bot.playersMu.Unlock()
+
+	if bot.playersCtx == nil || bot.playersCtx.Err() != nil {
+		return errors.New("players are not running")
+	}
+	for guildID, player := range bot.players {
+		if !player.running.Load() {
+			return fmt.Errorf("player of guild %s is stopped", guildID)
+		}
+	}
+
+	return nil
+}
+
+// Ready reports whether the bot is able to handle commands.
+func (bot *DiscoBot) Ready() error {
+	if bot.closing.Load() {
+		return errors.New("bot is shutting down")
+	}
+	if !bot.commandsRegistered.Load() {
+		return errors.New("commands are not registered")
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// Package library indexes a directory of audio files, so they can be searched and played without yt-dlp.
+package library
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	DefaultFfprobePath = "ffprobe"
+	DefaultFfmpegPath  = "ffmpeg"
+)
+
+var ErrNotFound = errors.New("the track is not found in the library")
+
+// extensions are the file extensions of indexed files.
+var extensions = map[string]bool{
+	".opus": true,
+	".ogg":  true,
+	".oga":  true,
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".aac":  true,
+	".wav":  true,
+	".webm": true,
+	".mka":  true,
+}
+
+type Config struct {
+	// Dir is the directory with the music, subdirectories are scanned too.
+	Dir         string
+	FfprobePath string
+	FfmpegPath  string
+	// Logger logs files which can't be indexed and the stderr of ffmpeg, defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Entry is an indexed file.
+type Entry struct {
+	// ID is a short stable identifier of the file, it fits the limits of Discord autocomplete choices.
+	ID string
+	// Path is the slash separated path relative to the library directory.
+	Path   string
+	Title  string
+	Artist string
+	Album  string
+	// Duration is 0 if it is unknown.
+	Duration time.Duration
+	// Passthrough reports whether the file is Ogg Opus with up to 2 channels and 20ms frames,
+	// such files are streamed as is.
+	Passthrough bool
+
+	size    int64
+	modTime time.Time
+}
+
+// Name returns the name of the track for users, e.g. "Artist - Title".
+func (e *Entry) Name() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// Library is the index of the music directory. It is empty until the first scan.
+type Library struct {
+	cfg Config
+
+	mu      sync.RWMutex
+	entries []*Entry
+	byID    map[string]*Entry
+	byPath  map[string]*Entry
+}
+
+func New(cfg Config) *Library {
+	if cfg.FfprobePath == "" {
+		cfg.FfprobePath = DefaultFfprobePath
+	}
+	if cfg.FfmpegPath == "" {
+		cfg.FfmpegPath = DefaultFfmpegPath
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return &Library{
+		cfg:    cfg,
+		byID:   map[string]*Entry{},
+		byPath: map[string]*Entry{},
+	}
+}
+
+// Run scans the directory and rescans it every interval until the context is done.
+// The directory is scanned once if the interval is 0.
+func (l *Library) Run(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		if err := l.Scan(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			l.cfg.Logger.Error("failed to scan the library", "dir", l.cfg.Dir, "err", err)
+		} else {
+			l.cfg.Logger.Info("library is scanned", "dir", l.cfg.Dir, "tracks", l.Len(), "took", time.Since(start))
+		}
+
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Scan indexes the directory. Files which are not changed since the previous scan aren't probed again.
+func (l *Library) Scan(ctx context.Context) error {
+	l.mu.RLock()
+	previous := l.byPath
+	l.mu.RUnlock()
+
+	var entries []*Entry
+	err := filepath.WalkDir(l.cfg.Dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if strings.HasPrefix(d.Name(), ".") && filePath != l.cfg.Dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !extensions[strings.ToLower(filepath.Ext(filePath))] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.cfg.Dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry, ok := previous[rel]; ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+			entries = append(entries, entry)
+			return nil
+		}
+
+		entry, err := l.probe(ctx, filePath)
+		if err != nil {
+			l.cfg.Logger.Warn("failed to index the file", "path", rel, "err", err)
+			return nil
+		}
+		entry.ID = fileID(rel)
+		entry.Path = rel
+		entry.size = info.Size()
+		entry.modTime = info.ModTime()
+		if entry.Title == "" {
+			entry.Title = strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	byID := make(map[string]*Entry, len(entries))
+	byPath := make(map[string]*Entry, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+		byPath[entry.Path] = entry
+	}
+
+	l.mu.Lock()
+	l.entries, l.byID, l.byPath = entries, byID, byPath
+	l.mu.Unlock()
+
+	return nil
+}
+
+func fileID(rel string) string {
+	sum := sha1.Sum([]byte(rel))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Len returns the number of indexed files.
+func (l *Library) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
+
+// Lookup returns the entry with the ID or the path.
+func (l *Library) Lookup(key string) (Entry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.byID[key]
+	if !ok {
+		entry, ok = l.byPath[key]
+	}
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Search returns up to limit entries matching all words of the query in the title, artist, album or path.
+// Entries with the title starting with the query go first.
+func (l *Library) Search(query string, limit int) []Entry {
+	query = strings.ToLower(strings.TrimSpace(query))
+	words := strings.Fields(query)
+
+	type match struct {
+		entry *Entry
+		rank  int
+	}
+
+	l.mu.RLock()
+	var matches []match
+	for _, entry := range l.entries {
+		haystack := strings.ToLower(strings.Join([]string{entry.Artist, entry.Title, entry.Album, entry.Path}, "\n"))
+		found := true
+		for _, word := range words {
+			if !strings.Contains(haystack, word) {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		title := strings.ToLower(entry.Title)
+		rank := 2
+		if strings.HasPrefix(title, query) {
+			rank = 0
+		} else if strings.Contains(title, query) {
+			rank = 1
+		}
+		matches = append(matches, match{entry: entry, rank: rank})
+	}
+	l.mu.RUnlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].rank < matches[j].rank
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	entries := make([]Entry, len(matches))
+	for i, m := range matches {
+		entries[i] = *m.entry
+	}
+	return entries
+}
+
+// OpenFile opens the file of the entry to stream it as is, it is Ogg Opus if the entry is Passthrough.
+func (l *Library) OpenFile(entry Entry) (*os.File, error) {
+	return os.Open(l.filePath(entry))
+}
+
+func (l *Library) filePath(entry Entry) string {
+	return filepath.Join(l.cfg.Dir, filepath.FromSlash(entry.Path))
+}
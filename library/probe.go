@@ -0,0 +1,155 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"discobot/ogg/opus"
+	"discobot/subprocess"
+)
+
+// passthroughFrameSamples is the frame size of the Discord voice, 20ms at 48kHz.
+const passthroughFrameSamples = 960
+
+// probe reads the tags and the duration of the file. Ogg Opus files are read by the opus package,
+// other formats and Ogg files with other codecs are probed by ffprobe.
+func (l *Library) probe(ctx context.Context, filePath string) (*Entry, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".opus", ".ogg", ".oga":
+		entry, err := probeOpus(filePath)
+		if err == nil {
+			return entry, nil
+		}
+		if strings.ToLower(filepath.Ext(filePath)) == ".opus" {
+			return nil, err
+		}
+	}
+	return l.ffprobe(ctx, filePath)
+}
+
+func probeOpus(filePath string) (*Entry, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder, err := opus.NewOpusDecoder(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		Title:  decoder.Comment("TITLE"),
+		Artist: decoder.Comment("ARTIST"),
+		Album:  decoder.Comment("ALBUM"),
+	}
+
+	// The duration is the sum of the packet durations, it doesn't depend on granule positions.
+	fixedFrames := true
+	var samples int
+	for {
+		packet, err := decoder.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(packet)
+		if err != nil {
+			return nil, err
+		}
+		n, err := opus.PacketSamples(data)
+		if err != nil {
+			return nil, err
+		}
+		if n != passthroughFrameSamples {
+			fixedFrames = false
+		}
+		samples += n
+	}
+
+	if samples > int(decoder.PreSkip()) {
+		samples -= int(decoder.PreSkip())
+		entry.Duration = time.Duration(samples) * time.Second / 48000
+	}
+	entry.Passthrough = fixedFrames && decoder.Channels() <= 2
+
+	return entry, nil
+}
+
+type ffprobeResult struct {
+	Streams []struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+func (l *Library) ffprobe(ctx context.Context, filePath string) (*Entry, error) {
+	cmd := exec.CommandContext(ctx, l.cfg.FfprobePath,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "format=duration:format_tags:stream_tags",
+		"-of", "json",
+		"-i", filePath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, fmt.Errorf("ffprobe: %w: %s", err, subprocess.LastLine(stderr.Bytes()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var result ffprobeResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return nil, errors.New("no audio stream")
+	}
+
+	// Containers keep tags either in the format or in the stream, e.g. Ogg Vorbis.
+	tag := func(name string) string {
+		if v := lookupTag(result.Format.Tags, name); v != "" {
+			return v
+		}
+		return lookupTag(result.Streams[0].Tags, name)
+	}
+	entry := &Entry{
+		Title:  tag("title"),
+		Artist: tag("artist"),
+		Album:  tag("album"),
+	}
+	if seconds, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil && seconds > 0 {
+		entry.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	return entry, nil
+}
+
+func lookupTag(tags map[string]string, name string) string {
+	for k, v := range tags {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
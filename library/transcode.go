@@ -0,0 +1,74 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"discobot/subprocess"
+
+	"golang.org/x/exp/slog"
+)
+
+// killDelay is the time given to ffmpeg to exit after the interrupt.
+const killDelay = 5 * time.Second
+
+type TranscodeOptions struct {
+	// Start is the position to start from.
+	Start time.Duration
+	// Volume is a multiplier of the output loudness, 0 and 1 keep the original one.
+	Volume float64
+	// Logger logs the stderr of ffmpeg at the debug level, defaults to the logger of the library.
+	Logger *slog.Logger
+}
+
+// Transcode converts the file of the entry to Ogg Opus with ffmpeg and writes it to w.
+func (l *Library) Transcode(ctx context.Context, entry Entry, w io.Writer, opts TranscodeOptions) error {
+	log := opts.Logger
+	if log == nil {
+		log = l.cfg.Logger.With("path", entry.Path)
+	}
+
+	var args []string
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', 3, 64))
+	}
+	args = append(args,
+		"-i", l.filePath(entry),
+		"-vn",
+	)
+	if opts.Volume != 0 && opts.Volume != 1 {
+		args = append(args, "-af", "volume="+strconv.FormatFloat(opts.Volume, 'f', 2, 64))
+	}
+	args = append(args,
+		"-acodec", "libopus",
+		"-f", "ogg",
+		"pipe:",
+	)
+
+	cmd := exec.CommandContext(ctx, l.cfg.FfmpegPath, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = killDelay
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	stderrLog := subprocess.NewLineLogger(log, "ffmpeg")
+	defer stderrLog.Flush()
+	cmd.Stderr = io.MultiWriter(&stderr, stderrLog)
+
+	err := cmd.Run()
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, subprocess.LastLine(stderr.Bytes()))
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
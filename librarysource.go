@@ -0,0 +1,73 @@
+package discobot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"discobot/library"
+)
+
+const librarySourceName = "local"
+
+// librarySource plays the files of the music library. Ogg Opus files are streamed as is,
+// other files and seeks are converted with ffmpeg.
+type librarySource struct {
+	library *library.Library
+}
+
+type libraryTrackData struct {
+	Path string `json:"path"`
+}
+
+func (s librarySource) Name() string {
+	return librarySourceName
+}
+
+// Resolve returns the file with the ID, e.g. an autocomplete choice, or the best match of the search.
+func (s librarySource) Resolve(_ context.Context, query string) (*Track, error) {
+	entry, ok := s.library.Lookup(query)
+	if !ok {
+		entries := s.library.Search(query, 1)
+		if len(entries) == 0 {
+			return nil, library.ErrNotFound
+		}
+		entry = entries[0]
+	}
+
+	data, err := json.Marshal(libraryTrackData{Path: entry.Path})
+	if err != nil {
+		return nil, err
+	}
+	return &Track{
+		Source:   librarySourceName,
+		Title:    entry.Name(),
+		URL:      entry.Path,
+		Duration: entry.Duration,
+		Seekable: entry.Duration > 0,
+		Data:     data,
+	}, nil
+}
+
+func (s librarySource) Open(ctx context.Context, track *Track, opts OpenOptions) (io.ReadCloser, error) {
+	var data libraryTrackData
+	if err := json.Unmarshal(track.Data, &data); err != nil {
+		return nil, err
+	}
+	entry, ok := s.library.Lookup(data.Path)
+	if !ok {
+		return nil, library.ErrNotFound
+	}
+
+	if entry.Passthrough && opts.Start == 0 && (opts.Volume == 0 || opts.Volume == 1) {
+		return s.library.OpenFile(entry)
+	}
+
+	return newPipeStream(ctx, func(ctx context.Context, w io.WriteCloser) error {
+		return s.library.Transcode(ctx, entry, w, library.TranscodeOptions{
+			Start:  opts.Start,
+			Volume: opts.Volume,
+			Logger: opts.Logger,
+		})
+	}), nil
+}
@@ -0,0 +1,85 @@
+// Package metrics defines the Prometheus metrics of the bot.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "discobot"
+
+var (
+	VoiceConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "voice_connections",
+		Help:      "Number of active voice connections.",
+	})
+	QueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_length",
+		Help:      "Number of queued tracks per guild.",
+	}, []string{"guild"})
+	TracksPlayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tracks_played_total",
+		Help:      "Number of played tracks by the result: finished, skipped or failed.",
+	}, []string{"result"})
+	FetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Time of fetching the track metadata with yt-dlp.",
+		Buckets:   []float64{0.5, 1, 2, 3, 5, 8, 13, 21, 34, 60},
+	})
+	MetadataCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "metadata_cache_requests_total",
+		Help:      "Number of lookups in the track metadata cache by the result: hit, miss or expired.",
+	}, []string{"result"})
+	AudioCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_cache_requests_total",
+		Help:      "Number of lookups in the audio cache by the result: hit or miss.",
+	}, []string{"result"})
+	AudioCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "audio_cache_size_bytes",
+		Help:      "Total size of the cached audio files.",
+	})
+	DownloadLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_latency_seconds",
+		Help:      "Time from starting the download to the first encoded audio.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 3, 5, 8, 13, 21},
+	})
+	Failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subprocess_failures_total",
+		Help:      "Number of yt-dlp and ffmpeg failures by the category.",
+	}, []string{"process", "category"})
+	OpusFramesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "opus_frames_sent_total",
+		Help:      "Number of Opus frames sent to voice connections.",
+	})
+	Underruns = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "underruns_total",
+		Help:      "Number of times the decoded audio ran out in the middle of a track.",
+	})
+	SilenceFrames = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "silence_frames_total",
+		Help:      "Number of silence frames sent while the audio was buffered after underruns.",
+	})
+	FrameJitter = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "frame_jitter_seconds",
+		Help:      "Lateness of sent Opus frames relative to the 20ms clock.",
+		Buckets:   []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1},
+	})
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Number of handled slash commands.",
+	}, []string{"command"})
+)
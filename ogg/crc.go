@@ -0,0 +1,25 @@
+package ogg
+
+// crcTable is the CRC-32 table of Ogg: polynomial 0x04c11db7, no reflection,
+// zero initial value and no final XOR, so hash/crc32 can't be used.
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func crcUpdate(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
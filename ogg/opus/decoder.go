@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strings"
 )
 
 var endian = binary.LittleEndian
@@ -145,3 +146,29 @@ func (od *OpusDecorder) readTags() error {
 
 	return nil
 }
+
+// Channels returns the number of output channels.
+func (od *OpusDecorder) Channels() uint8 {
+	return od.outputChannels
+}
+
+// PreSkip returns the number of samples to discard from the decoder output at the start.
+func (od *OpusDecorder) PreSkip() uint16 {
+	return od.preSkip
+}
+
+func (od *OpusDecorder) Vendor() string {
+	return od.vendor
+}
+
+// Comment returns the value of the first user comment with the field name, e.g. TITLE or ARTIST.
+// Field names are case-insensitive.
+func (od *OpusDecorder) Comment(name string) string {
+	for _, comment := range od.userComments {
+		field, value, ok := strings.Cut(comment, "=")
+		if ok && strings.EqualFold(field, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package opus
+
+import (
+	"bytes"
+	"discobot/ogg"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	sampleRate = 48000
+	vendor     = "discobot"
+)
+
+// Writer writes Opus packets into an Ogg Opus stream.
+type Writer struct {
+	pw      *ogg.PageWriter
+	granule uint64
+	// pending is the last packet, it is written on the next packet or on close,
+	// so the last page can be marked with EndOfStreamFlag.
+	pending []byte
+}
+
+func NewWriter(w io.Writer, channels uint8, serial uint32) (*Writer, error) {
+	ow := &Writer{pw: ogg.NewPageWriter(w, serial)}
+	if err := ow.writeHeaders(channels); err != nil {
+		return nil, err
+	}
+	return ow, nil
+}
+
+func (ow *Writer) writeHeaders(channels uint8) error {
+	var head bytes.Buffer
+	head.WriteString("OpusHead")
+	head.WriteByte(1) // version
+	head.WriteByte(channels)
+	_ = binary.Write(&head, endian, uint16(0)) // pre-skip
+	_ = binary.Write(&head, endian, uint32(sampleRate))
+	_ = binary.Write(&head, endian, int16(0)) // output gain
+	head.WriteByte(0)                         // channel mapping family
+	if err := ow.pw.WritePacket(head.Bytes(), 0, 0); err != nil {
+		return err
+	}
+
+	var tags bytes.Buffer
+	tags.WriteString("OpusTags")
+	_ = binary.Write(&tags, endian, uint32(len(vendor)))
+	tags.WriteString(vendor)
+	_ = binary.Write(&tags, endian, uint32(0)) // user comments
+	return ow.pw.WritePacket(tags.Bytes(), 0, 0)
+}
+
+// WritePacket writes the Opus packet, the packet is copied.
+func (ow *Writer) WritePacket(packet []byte) error {
+	if _, err := PacketSamples(packet); err != nil {
+		return err
+	}
+	if err := ow.flush(0); err != nil {
+		return err
+	}
+	ow.pending = append(ow.pending[:0], packet...)
+	return nil
+}
+
+// Close writes the last packet and ends the stream, the underlying writer is not closed.
+func (ow *Writer) Close() error {
+	if len(ow.pending) == 0 {
+		// Ogg requires the end of the stream to be marked on a page
+		return ow.pw.WritePacket(nil, ow.granule, ogg.EndOfStreamFlag)
+	}
+	return ow.flush(ogg.EndOfStreamFlag)
+}
+
+func (ow *Writer) flush(headerType ogg.HeaderType) error {
+	if len(ow.pending) == 0 {
+		return nil
+	}
+	samples, _ := PacketSamples(ow.pending)
+	ow.granule += uint64(samples)
+	if err := ow.pw.WritePacket(ow.pending, ow.granule, headerType); err != nil {
+		return err
+	}
+	ow.pending = ow.pending[:0]
+	return nil
+}
+
+// frameSamples are the frame sizes at 48 kHz by the configuration of the TOC byte, see RFC 6716 section 3.1.
+var frameSamples = [32]int{
+	480, 960, 1920, 2880, // SILK NB
+	480, 960, 1920, 2880, // SILK MB
+	480, 960, 1920, 2880, // SILK WB
+	480, 960, // Hybrid SWB
+	480, 960, // Hybrid FB
+	120, 240, 480, 960, // CELT NB
+	120, 240, 480, 960, // CELT WB
+	120, 240, 480, 960, // CELT SWB
+	120, 240, 480, 960, // CELT FB
+}
+
+// PacketSamples returns the number of samples at 48 kHz in the Opus packet.
+func PacketSamples(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, errors.New("empty Opus packet")
+	}
+
+	toc := packet[0]
+	samples := frameSamples[toc>>3]
+	switch toc & 0x3 {
+	case 0:
+		return samples, nil
+	case 1, 2:
+		return 2 * samples, nil
+	default:
+		if len(packet) < 2 {
+			return 0, errors.New("invalid Opus packet: frame count is missing")
+		}
+		return int(packet[1]&0x3f) * samples, nil
+	}
+}
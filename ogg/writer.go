@@ -0,0 +1,58 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxPacketSize is the size of the largest packet fitting into a single page.
+const maxPacketSize = 255 * 255
+
+const pageHeaderSize = 27
+
+// PageWriter writes packets of a single logical stream, one packet per page.
+type PageWriter struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+}
+
+func NewPageWriter(w io.Writer, serial uint32) *PageWriter {
+	return &PageWriter{w: w, serial: serial}
+}
+
+// WritePacket writes the packet as a page with the granule position.
+// The first page gets BeginningOfStreamFlag, EndOfStreamFlag is set by the caller.
+func (pw *PageWriter) WritePacket(packet []byte, granule uint64, headerType HeaderType) error {
+	if len(packet) >= maxPacketSize {
+		return errors.New("packet is too large")
+	}
+	if pw.sequence == 0 {
+		headerType |= BeginningOfStreamFlag
+	}
+
+	segments := len(packet)/255 + 1
+	page := make([]byte, pageHeaderSize+segments+len(packet))
+	copy(page, "OggS")
+	page[4] = 0 // version
+	page[5] = byte(headerType)
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], pw.serial)
+	binary.LittleEndian.PutUint32(page[18:], pw.sequence)
+	// page[22:26] is the checksum, it is computed with zeros in its place
+	page[26] = byte(segments)
+	for i := 0; i < segments-1; i++ {
+		page[pageHeaderSize+i] = 255
+	}
+	page[pageHeaderSize+segments-1] = byte(len(packet) % 255)
+	copy(page[pageHeaderSize+segments:], packet)
+
+	binary.LittleEndian.PutUint32(page[22:], crcUpdate(0, page))
+
+	if _, err := pw.w.Write(page); err != nil {
+		return err
+	}
+	pw.sequence++
+	return nil
+}
@@ -0,0 +1,103 @@
+package discobot
+
+import (
+	"time"
+
+	"discobot/audiocache"
+	"discobot/library"
+	"discobot/settings"
+	"discobot/ytdlp"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+type Option func(bot *DiscoBot)
+
+// WithSettingsStore sets the store of guild settings.
+// By default settings are kept in memory only.
+func WithSettingsStore(store settings.Store) Option {
+	return func(bot *DiscoBot) {
+		bot.settings = store
+	}
+}
+
+// WithSnapshotDir enables saving of the queues to the directory,
+// so playback is resumed after restart.
+func WithSnapshotDir(dir string) Option {
+	return func(bot *DiscoBot) {
+		bot.snapshots = &snapshotStore{dir: dir}
+	}
+}
+
+// WithYtDlp sets the client used to fetch and download tracks.
+func WithYtDlp(client *ytdlp.Client) Option {
+	return WithSource(ytdlpSource{client: client})
+}
+
+// WithAudioCache caches the audio of the tracks downloaded with yt-dlp.
+func WithAudioCache(cache *audiocache.Cache) Option {
+	return func(bot *DiscoBot) {
+		bot.audioCache = cache
+	}
+}
+
+// WithLibrary enables playing files of the music library with /disco local.
+func WithLibrary(lib *library.Library) Option {
+	return func(bot *DiscoBot) {
+		bot.library = lib
+		WithSource(librarySource{library: lib})(bot)
+	}
+}
+
+// WithSource adds the source of tracks, a source with the same name is replaced.
+func WithSource(source Source) Option {
+	return func(bot *DiscoBot) {
+		bot.sources[source.Name()] = source
+	}
+}
+
+// WithGuildAllowlist restricts the bot to the given guilds, other guilds are ignored.
+func WithGuildAllowlist(guildIDs ...dg.Snowflake) Option {
+	return func(bot *DiscoBot) {
+		bot.allowedGuilds = make(map[dg.Snowflake]bool, len(guildIDs))
+		for _, guildID := range guildIDs {
+			bot.allowedGuilds[guildID] = true
+		}
+	}
+}
+
+// WithMaxQueueLength limits the queue length guilds can configure.
+func WithMaxQueueLength(length int) Option {
+	return func(bot *DiscoBot) {
+		bot.maxQueueLength = length
+	}
+}
+
+// WithPreBuffer sets the audio buffered before the players start speaking and after underruns,
+// by default it is DefaultPreBuffer.
+func WithPreBuffer(d time.Duration) Option {
+	return func(bot *DiscoBot) {
+		bot.prebuffer = d
+	}
+}
+
+// WithFfmpegPath sets the ffmpeg binary used to crossfade tracks, by default it is found in PATH.
+func WithFfmpegPath(path string) Option {
+	return func(bot *DiscoBot) {
+		bot.ffmpegPath = path
+	}
+}
+
+// WithCommandTimeout limits the time of handling a command, by default it is DefaultCommandTimeout.
+func WithCommandTimeout(d time.Duration) Option {
+	return func(bot *DiscoBot) {
+		bot.commandTimeout = d
+	}
+}
+
+// WithVoiceConnector sets the connector of voice channels, by default the audio is sent to Discord.
+func WithVoiceConnector(connector VoiceConnector) Option {
+	return func(bot *DiscoBot) {
+		bot.voice = connector
+	}
+}
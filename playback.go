@@ -3,8 +3,11 @@ package discobot
 import (
 	"context"
 	"errors"
+	"sync"
 )
 
+var errTrackSkipped = errors.New("track is skipped")
+
 type PlayStatus int
 
 const (
@@ -14,50 +17,94 @@ const (
 )
 
 type Playback struct {
-	playStatus    PlayStatus
+	// mu guards the play status, it is changed by the player, the commands and the admin API.
+	mu         sync.Mutex
+	playStatus PlayStatus
+	// startPlayback wakes up Check after Resume, the buffer keeps the wakeup
+	// if Check has read the paused status but hasn't started waiting yet.
 	startPlayback chan struct{}
 	skipCurrent   chan struct{}
+	// onChange is called after the play status is changed.
+	onChange func(PlayStatus)
 }
 
 func NewPlayback() Playback {
 	return Playback{
 		playStatus:    IdlePlayStatus,
-		startPlayback: make(chan struct{}),
+		startPlayback: make(chan struct{}, 1),
 		skipCurrent:   make(chan struct{}, 1),
 	}
 }
 
-func (pb *Playback) Resume() {
-	if pb.playStatus != PausedPlayStatus {
-		return
-	}
+func (pb *Playback) Status() PlayStatus {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	return pb.playStatus
+}
 
-	pb.playStatus = PlayingPlayStatus
-	select {
-	case pb.startPlayback <- struct{}{}:
-	default:
-		// Skip if nobody is waiting
-	}
+func (pb *Playback) Resume() {
+	pb.swapStatus(PausedPlayStatus, PlayingPlayStatus, func() {
+		select {
+		case pb.startPlayback <- struct{}{}:
+		default:
+			// Skip if the wakeup is already pending
+		}
+	})
 }
 
 func (pb *Playback) StartCurrentTrack() {
-	pb.playStatus = PlayingPlayStatus
+	pb.setStatus(PlayingPlayStatus)
 }
 
 func (pb *Playback) FinishCurrentTrack() {
-	pb.playStatus = IdlePlayStatus
+	pb.setStatus(IdlePlayStatus)
 }
 
 func (pb *Playback) Pause() {
-	if pb.playStatus != PlayingPlayStatus {
-		return
+	pb.swapStatus(PlayingPlayStatus, PausedPlayStatus, func() {
+		// drop the wakeup of the previous Resume if Check hasn't taken it
+		select {
+		case <-pb.startPlayback:
+		default:
+		}
+	})
+}
+
+func (pb *Playback) setStatus(status PlayStatus) {
+	pb.mu.Lock()
+	changed := pb.playStatus != status
+	pb.playStatus = status
+	pb.mu.Unlock()
+
+	if changed {
+		pb.notify(status)
 	}
+}
 
-	pb.playStatus = PausedPlayStatus
+// swapStatus changes the status only if it is old, swapped is called under the lock after the change.
+func (pb *Playback) swapStatus(old, status PlayStatus, swapped func()) {
+	pb.mu.Lock()
+	ok := pb.playStatus == old
+	if ok {
+		pb.playStatus = status
+		swapped()
+	}
+	pb.mu.Unlock()
+
+	if ok {
+		pb.notify(status)
+	}
+}
+
+// notify calls onChange without the lock, so it may read the status.
+func (pb *Playback) notify(status PlayStatus) {
+	if pb.onChange != nil {
+		pb.onChange(status)
+	}
 }
 
 func (pb *Playback) Skip() {
-	if pb.playStatus == IdlePlayStatus {
+	if pb.Status() == IdlePlayStatus {
 		return
 	}
 
@@ -73,13 +120,13 @@ func (pb *Playback) Check(ctx context.Context) error {
 		return ctx.Err()
 	}
 
-	if pb.playStatus != PlayingPlayStatus {
+	if pb.Status() != PlayingPlayStatus {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-pb.startPlayback:
 		case <-pb.skipCurrent:
-			return errors.New("track is skipped")
+			return errTrackSkipped
 		}
 	}
 
@@ -87,7 +134,7 @@ func (pb *Playback) Check(ctx context.Context) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-pb.skipCurrent:
-		return errors.New("track is skipped")
+		return errTrackSkipped
 	default:
 	}
 
@@ -0,0 +1,33 @@
+package discobot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPlaybackKeepsTheWakeup(t *testing.T) {
+	playback := playingPlayback()
+	playback.Pause()
+	// Check has read the paused status, Resume runs before it waits
+	playback.Resume()
+
+	select {
+	case <-playback.startPlayback:
+	case <-time.After(time.Second):
+		t.Fatal("the wakeup of Resume is lost")
+	}
+}
+
+func TestPlaybackPauseDropsTheWakeup(t *testing.T) {
+	playback := playingPlayback()
+	playback.Pause()
+	playback.Resume()
+	playback.Pause()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*frameDuration)
+	defer cancel()
+	if err := playback.Check(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,578 @@
+package discobot
+
+import (
+	"context"
+	"discobot/metrics"
+	"discobot/settings"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dg "github.com/andersfylling/disgord"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/errgroup"
+)
+
+// frameDuration is the duration of a single Opus frame produced by ffmpeg.
+const frameDuration = 20 * time.Millisecond
+
+// frameSamples is the number of samples of a frame at 48kHz, the voice connection sends only such frames.
+const frameSamples = 960
+
+// maxResumes limits how many times a failed track is resumed.
+const maxResumes = 2
+
+// Player plays the queued tracks of a single guild.
+type Player struct {
+	guildID   dg.Snowflake
+	client    *dg.Client
+	voice     VoiceConnector
+	sources   map[string]Source
+	settings  settings.Store
+	snapshots *snapshotStore
+	log       *slog.Logger
+
+	playback    Playback
+	queue       *Queue[*Task]
+	currentTask atomic.Pointer[Task]
+	elapsed     atomic.Int64
+	skipVotes   SkipVotes
+	// running is set while the player goroutine is alive.
+	running atomic.Bool
+	// onChange is called after the playback, the current track or the queue are changed.
+	onChange func(guildID dg.Snowflake)
+	// prebuffer is the audio buffered before speaking and after underruns.
+	prebuffer time.Duration
+	mixer     crossfader
+
+	snapshotMu sync.Mutex
+}
+
+func newPlayer(client *dg.Client, voice VoiceConnector, sources map[string]Source, store settings.Store, snapshots *snapshotStore, guildID dg.Snowflake, queueCapacity int, prebuffer time.Duration, ffmpegPath string, onChange func(dg.Snowflake)) *Player {
+	p := &Player{
+		guildID:   guildID,
+		client:    client,
+		voice:     voice,
+		sources:   sources,
+		settings:  store,
+		snapshots: snapshots,
+		log:       logger.With("guild", guildID),
+		playback:  NewPlayback(),
+		queue:     NewQueue[*Task](queueCapacity),
+		skipVotes: NewSkipVotes(),
+		onChange:  onChange,
+		prebuffer: prebuffer,
+		mixer:     crossfader{ffmpegPath: ffmpegPath},
+	}
+	p.playback.onChange = func(PlayStatus) { p.changed() }
+	return p
+}
+
+func (p *Player) Enqueue(task *Task) error {
+	if err := p.queue.Push(task); err != nil {
+		return err
+	}
+	p.queueChanged()
+	p.saveSnapshot()
+	return nil
+}
+
+// Clean removes all queued tracks and skips the current one.
+func (p *Player) Clean() {
+	p.queue.Clean()
+	p.playback.Skip()
+	p.queueChanged()
+	p.saveSnapshot()
+}
+
+// ClearQueue removes all queued tracks.
+func (p *Player) ClearQueue() {
+	p.queue.Clean()
+	p.queueChanged()
+	p.saveSnapshot()
+}
+
+// MoveTrack moves the queued track from one position to another.
+func (p *Player) MoveTrack(from, to int) error {
+	if err := p.queue.Move(from, to); err != nil {
+		return err
+	}
+	p.queueChanged()
+	p.saveSnapshot()
+	return nil
+}
+
+func (p *Player) queueChanged() {
+	metrics.QueueLength.WithLabelValues(p.guildID.String()).Set(float64(p.queue.Len()))
+	p.changed()
+}
+
+// changed notifies that the state of the player is changed.
+func (p *Player) changed() {
+	if p.onChange != nil {
+		p.onChange(p.guildID)
+	}
+}
+
+// Elapsed returns the position of the current track.
+func (p *Player) Elapsed() time.Duration {
+	return time.Duration(p.elapsed.Load())
+}
+
+// loop returns the task to play again in the LoopTrack mode, in the LoopQueue mode it is queued again.
+func (p *Player) loop(task *Task, mode settings.LoopMode) *Task {
+	restarted := *task
+	restarted.start = 0
+	restarted.resumes = 0
+
+	switch mode {
+	case settings.LoopTrack:
+		return &restarted
+	case settings.LoopQueue:
+		// the track was in the queue, so the loop isn't limited by the capacity
+		p.queue.ForcePush(&restarted)
+		p.queueChanged()
+	}
+	return nil
+}
+
+// saveSnapshot saves the queue and the current track with its position if snapshots are enabled.
+func (p *Player) saveSnapshot() {
+	if p.snapshots == nil {
+		return
+	}
+
+	p.snapshotMu.Lock()
+	defer p.snapshotMu.Unlock()
+
+	var snapshot playerSnapshot
+	if task := p.currentTask.Load(); task != nil {
+		current := newTaskSnapshot(task)
+		snapshot.Current = &current
+		snapshot.Position = p.Elapsed()
+	}
+	for _, task := range p.queue.Items() {
+		snapshot.Queue = append(snapshot.Queue, newTaskSnapshot(task))
+	}
+
+	if err := p.snapshots.save(p.guildID, snapshot); err != nil {
+		p.log.Error("failed to save the player snapshot", "err", err)
+	}
+}
+
+// restore queues the tracks of the snapshot, the current track is resumed from the saved position.
+func (p *Player) restore(snapshot playerSnapshot) {
+	if snapshot.Current != nil {
+		if task := snapshot.Current.task(p.guildID); task != nil {
+			task.start = snapshot.Position
+			if err := p.queue.Push(task); err != nil {
+				p.log.Error("failed to restore the current track", "track", task.track.URL, "err", err)
+			}
+		}
+	}
+	for _, ts := range snapshot.Queue {
+		task := ts.task(p.guildID)
+		if task == nil {
+			p.log.Warn("skipped the queued track without source")
+			continue
+		}
+		if err := p.queue.Push(task); err != nil {
+			p.log.Error("failed to restore the queued track", "track", task.track.URL, "err", err)
+		}
+	}
+	p.queueChanged()
+}
+
+func (p *Player) RunPlayer(ctx context.Context) error {
+	var voice VoiceSink
+	var out *sender
+	// prefetched is the stream of the next track opened while the current one finishes.
+	var prefetched *trackStream
+	// tail is the end of the finished track held back to be mixed with the next one.
+	var tail [][]byte
+	defer func() {
+		if prefetched != nil {
+			prefetched.Close()
+		}
+		if voice != nil {
+			voice.Close()
+			metrics.VoiceConnections.Dec()
+		}
+	}()
+
+	var next *Task
+	for {
+		task := next
+		next = nil
+		if task == nil {
+			var err error
+			task, err = p.queue.Pop(ctx)
+			if err != nil {
+				return err
+			}
+			p.queueChanged()
+		}
+
+		log := p.log.With("track", task.track.URL, "source", task.track.Source, "user", task.requesterID)
+		guildSettings := p.guildSettings(log)
+
+		if voice == nil {
+			// Join the provided voice channel.
+			var err error
+			voice, err = p.voice.Connect(task.guildID, task.channelID)
+			if err != nil {
+				log.Error("failed to join the voice channel", "channel", task.channelID, "err", err)
+				metrics.TracksPlayed.WithLabelValues("failed").Inc()
+				go p.notify(ctx, log, guildSettings, task, fmt.Sprintf("Failed to join the voice channel, skipped %s", trackName(task.track)))
+				p.saveSnapshot()
+				continue
+			}
+			metrics.VoiceConnections.Inc()
+			out = newSender(voice, &p.playback, p.prebuffer)
+		}
+
+		if task.resumes == 0 {
+			go p.announce(ctx, log, guildSettings, task)
+		}
+
+		stream := prefetched
+		prefetched = nil
+		// the prefetched stream starts with the tail of the previous track, it's dropped if the track is skipped
+		if stream != nil && (!stream.matches(task) || stream.crossfaded && tail == nil) {
+			stream.Close()
+			stream = nil
+		}
+
+		log.Info("playing the track", "start", task.start, "prefetched", stream != nil)
+		var err error
+		tail, err = p.play(ctx, log, out, task, stream, tail, guildSettings, func(tail [][]byte) {
+			prefetched = p.prefetch(ctx, task, tail)
+		})
+		switch {
+		case errors.Is(err, errTrackSkipped):
+			log.Info("track is skipped")
+			metrics.TracksPlayed.WithLabelValues("skipped").Inc()
+		case err != nil && ctx.Err() == nil:
+			log.Error("failed to play the track", "err", err)
+			metrics.TracksPlayed.WithLabelValues("failed").Inc()
+		case err == nil:
+			metrics.TracksPlayed.WithLabelValues("finished").Inc()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if resumed := p.resume(ctx, log, task, err); resumed != nil {
+			next = resumed
+		} else if !errors.Is(err, errTrackSkipped) {
+			next = p.loop(task, guildSettings.LoopMode)
+		}
+		p.saveSnapshot()
+
+		if next == nil && p.queue.Len() == 0 {
+			if len(tail) > 0 {
+				if err := p.playTail(ctx, out, tail); err != nil && !errors.Is(err, errTrackSkipped) {
+					log.Error("failed to play the end of the track", "err", err)
+				}
+				tail = nil
+			}
+			if err := out.stop(ctx); err != nil {
+				log.Error("failed to stop speaking", "err", err)
+			}
+			if prefetched != nil {
+				prefetched.Close()
+				prefetched = nil
+			}
+			voice.Close()
+			voice = nil
+			metrics.VoiceConnections.Dec()
+		}
+	}
+}
+
+// resume returns the task resuming the track failed by its download from the failure position, the track
+// is resolved again in case its stream URLs expired. It returns nil if the track isn't failed by the download,
+// e.g. the voice connection or the decoder failed, or it can't be resumed.
+func (p *Player) resume(ctx context.Context, log *slog.Logger, task *Task, err error) *Task {
+	var downloadErr *downloadError
+	if !errors.As(err, &downloadErr) || ctx.Err() != nil || task.resumes >= maxResumes {
+		return nil
+	}
+	source, ok := p.sources[task.track.Source].(refresher)
+	if !ok {
+		return nil
+	}
+
+	track, err := source.Refresh(ctx, task.track)
+	if err != nil {
+		log.Error("failed to resolve the track again", "err", err)
+		return nil
+	}
+
+	resumed := *task
+	resumed.track = track
+	resumed.start = p.Elapsed()
+	resumed.resumes++
+	log.Info("resuming the failed track", "position", resumed.start, "attempt", resumed.resumes)
+	return &resumed
+}
+
+func (p *Player) guildSettings(log *slog.Logger) settings.Guild {
+	guildSettings, err := p.settings.Get(p.guildID)
+	if err != nil {
+		log.Error("failed to get guild settings", "err", err)
+		return settings.Default()
+	}
+	return guildSettings
+}
+
+// prefetch opens the track following the current one: the same track if it is looped or the first queued one.
+// The queue may change before the current track ends, so the stream is used only if it matches the next task.
+// The tail of the current track is mixed with the beginning of the next one.
+func (p *Player) prefetch(ctx context.Context, current *Task, tail [][]byte) *trackStream {
+	log := p.log.With("track", current.track.URL)
+	guildSettings := p.guildSettings(log)
+
+	task := current
+	if guildSettings.LoopMode == settings.LoopTrack {
+		restarted := *current
+		restarted.start = 0
+		task = &restarted
+	} else {
+		var ok bool
+		if task, ok = p.queue.Peek(); !ok {
+			return nil
+		}
+	}
+
+	log = p.log.With("track", task.track.URL, "source", task.track.Source, "user", task.requesterID)
+	stream, err := p.openTrack(ctx, log, task, guildSettings)
+	if err != nil {
+		log.Warn("failed to prefetch the next track", "err", err)
+		return nil
+	}
+	if len(tail) > 0 {
+		stream.crossfadeFrom(p.mixer, log, tail)
+	}
+	log.Debug("prefetching the next track", "crossfade", stream.crossfaded)
+	return stream
+}
+
+// play plays the task from the stream, the stream is opened if it isn't prefetched and mixed with the tail
+// of the previous track. prefetchNext is called with the tail of the track when the whole track is decoded.
+// The tail is returned if the track is finished.
+func (p *Player) play(ctx context.Context, log *slog.Logger, out *sender, task *Task, stream *trackStream, tail [][]byte, guildSettings settings.Guild, prefetchNext func(tail [][]byte)) ([][]byte, error) {
+	p.currentTask.Store(task)
+	p.elapsed.Store(int64(task.startPosition()))
+	p.skipVotes.Reset(task)
+	p.saveSnapshot()
+
+	p.playback.StartCurrentTrack()
+	defer p.playback.FinishCurrentTrack()
+	defer func() {
+		p.currentTask.Store(nil)
+		p.skipVotes.Reset(nil)
+	}()
+
+	if stream == nil {
+		var err error
+		if stream, err = p.openTrack(ctx, log, task, guildSettings); err != nil {
+			return nil, err
+		}
+		if len(tail) > 0 {
+			stream.crossfadeFrom(p.mixer, log, tail)
+		}
+	}
+	defer stream.Close()
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		defer log.Debug("stage stopped", "stage", "sender")
+
+		return out.play(ctx, stream.packets, func() {
+			p.elapsed.Add(int64(frameDuration))
+		})
+	})
+	eg.Go(func() error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stream.decoded:
+		}
+		if stream.err != nil {
+			return stream.err
+		}
+		prefetchNext(stream.tail)
+		return nil
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return stream.tail, nil
+}
+
+// playTail plays the end of the last track held back for the crossfade.
+func (p *Player) playTail(ctx context.Context, out *sender, tail [][]byte) error {
+	p.playback.StartCurrentTrack()
+	defer p.playback.FinishCurrentTrack()
+
+	packets := make(chan []byte, len(tail))
+	for _, packet := range tail {
+		packets <- packet
+	}
+	close(packets)
+	return out.play(ctx, packets, func() {})
+}
+
+// trackStream is the track decoded in the background. The decoded frames are buffered,
+// so the next track is prefetched while the current one finishes.
+type trackStream struct {
+	task    *Task
+	start   time.Duration
+	packets chan []byte
+	// decoded is closed when the track is decoded or the decoder failed, err is set before.
+	decoded chan struct{}
+	err     error
+	// tail are the last frames held back to be mixed with the next track, it is set before decoded is closed.
+	tail [][]byte
+	// crossfaded is set if the stream starts with the tail of the previous track.
+	crossfaded bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mixing is done when the crossfade stage stops.
+	mixing sync.WaitGroup
+}
+
+// openTrack opens the source of the task and starts decoding it.
+func (p *Player) openTrack(ctx context.Context, log *slog.Logger, task *Task, guildSettings settings.Guild) (*trackStream, error) {
+	source, ok := p.sources[task.track.Source]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", task.track.Source)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	start := task.startPosition()
+	r, err := source.Open(ctx, task.track, OpenOptions{
+		Start:  start,
+		Volume: float64(guildSettings.Volume) / 100,
+		Logger: log.With("stage", "source"),
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("source: %w", err)
+	}
+
+	stream := &trackStream{
+		task:    task,
+		start:   start,
+		packets: make(chan []byte, 2048),
+		decoded: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	packets := stream.packets
+	holdBack := crossfadeFrames(task, guildSettings)
+	go func() {
+		defer func() {
+			close(packets)
+			r.Close()
+			log.Debug("stage stopped", "stage", "decoder")
+			close(stream.decoded)
+		}()
+
+		tail, err := decodeOpusToChan(ctx, r, packets, holdBack)
+		if err != nil {
+			stream.err = fmt.Errorf("decoder: %w", err)
+			return
+		}
+		stream.tail = tail
+	}()
+
+	return stream, nil
+}
+
+// crossfadeFrames returns the number of frames at the end of the task held back for the crossfade.
+// Tracks of unknown duration and tracks shorter than two crossfades aren't mixed.
+func crossfadeFrames(task *Task, guildSettings settings.Guild) int {
+	crossfade := time.Duration(guildSettings.Crossfade) * time.Second
+	if crossfade == 0 || task.track.Duration-task.startPosition() < 2*crossfade {
+		return 0
+	}
+	return int(crossfade / frameDuration)
+}
+
+// crossfadeFrom mixes the beginning of the stream with the tail of the previous track.
+func (s *trackStream) crossfadeFrom(mixer crossfader, log *slog.Logger, tail [][]byte) {
+	packets := s.packets
+	mixed := make(chan []byte, cap(packets))
+	s.packets = mixed
+	s.crossfaded = true
+
+	s.mixing.Add(1)
+	go func() {
+		defer s.mixing.Done()
+		defer close(mixed)
+		defer log.Debug("stage stopped", "stage", "crossfade")
+
+		mixer.crossfade(s.ctx, log.With("stage", "crossfade"), tail, packets, mixed)
+	}()
+}
+
+// matches reports whether the stream plays the task, e.g. the prefetched track is still the next one.
+func (s *trackStream) matches(task *Task) bool {
+	return s.task.track == task.track && s.start == task.startPosition()
+}
+
+// Close stops decoding and waits for the decoder and the crossfade.
+func (s *trackStream) Close() {
+	s.cancel()
+	<-s.decoded
+	s.mixing.Wait()
+}
+
+// announce posts the track to the announcement channel of the guild if it is configured.
+func (p *Player) announce(ctx context.Context, log *slog.Logger, guildSettings settings.Guild, task *Task) {
+	if guildSettings.AnnouncementChannelID.IsZero() {
+		return
+	}
+
+	content := "Now playing " + trackName(task.track)
+	_, err := p.client.Channel(guildSettings.AnnouncementChannelID).WithContext(ctx).CreateMessage(&dg.CreateMessage{
+		Content: content,
+	})
+	if err != nil {
+		log.Error("failed to announce the track", "channel", guildSettings.AnnouncementChannelID, "err", err)
+	}
+}
+
+// notify sends the message about the task to the channel the track was requested in,
+// tracks queued without Discord are reported to the announcement channel.
+func (p *Player) notify(ctx context.Context, log *slog.Logger, guildSettings settings.Guild, task *Task, content string) {
+	channelID := task.textChannelID
+	if channelID.IsZero() {
+		channelID = guildSettings.AnnouncementChannelID
+	}
+	if channelID.IsZero() {
+		return
+	}
+
+	_, err := p.client.Channel(channelID).WithContext(ctx).CreateMessage(&dg.CreateMessage{
+		Content: content,
+	})
+	if err != nil {
+		log.Error("failed to send the message", "channel", channelID, "err", err)
+	}
+}
+
+// trackName returns the title with the URL of the track for messages.
+func trackName(track *Track) string {
+	if track.Title == "" {
+		return track.URL
+	}
+	return fmt.Sprintf("**%s** (%s)", track.Title, track.URL)
+}
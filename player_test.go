@@ -0,0 +1,165 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"discobot/settings"
+
+	"golang.org/x/exp/slog"
+)
+
+// fakeSource refreshes tracks by adding the number of refreshes to the URL.
+type fakeSource struct {
+	refreshes int
+	err       error
+}
+
+func (s *fakeSource) Name() string { return "fake" }
+
+func (s *fakeSource) Resolve(ctx context.Context, query string) (*Track, error) {
+	return &Track{Source: s.Name(), URL: query}, nil
+}
+
+func (s *fakeSource) Open(ctx context.Context, track *Track, opts OpenOptions) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeSource) Refresh(ctx context.Context, track *Track) (*Track, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.refreshes++
+	refreshed := *track
+	refreshed.URL = fmt.Sprintf("%s#%d", track.URL, s.refreshes)
+	return &refreshed, nil
+}
+
+// staticSource is a source whose tracks don't expire.
+type staticSource struct{}
+
+func (staticSource) Name() string { return "static" }
+
+func (staticSource) Resolve(ctx context.Context, query string) (*Track, error) {
+	return &Track{Source: "static", URL: query}, nil
+}
+
+func (staticSource) Open(ctx context.Context, track *Track, opts OpenOptions) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestPlayerLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      settings.LoopMode
+		wantNext  bool
+		wantQueue int
+	}{
+		{name: "off", mode: settings.LoopOff, wantQueue: 2},
+		{name: "track", mode: settings.LoopTrack, wantNext: true, wantQueue: 2},
+		// the queue is full, the looped track is queued over the capacity
+		{name: "queue", mode: settings.LoopQueue, wantQueue: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Player{queue: NewQueue[*Task](2)}
+			for i := 0; i < 2; i++ {
+				if err := p.queue.Push(&Task{track: &Track{URL: "queued"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			task := &Task{track: &Track{URL: "finished"}, start: 30, resumes: 2}
+
+			next := p.loop(task, tt.mode)
+			if (next != nil) != tt.wantNext {
+				t.Fatalf("got next task %v, want %v", next != nil, tt.wantNext)
+			}
+			if p.queue.Len() != tt.wantQueue {
+				t.Fatalf("got %d queued tasks, want %d", p.queue.Len(), tt.wantQueue)
+			}
+
+			restarted := next
+			if tt.mode == settings.LoopQueue {
+				items := p.queue.Items()
+				restarted = items[len(items)-1]
+			}
+			if restarted == nil {
+				return
+			}
+			if restarted == task || restarted.track != task.track {
+				t.Error("the looped task isn't a copy of the finished one")
+			}
+			if restarted.start != 0 || restarted.resumes != 0 {
+				t.Errorf("the looped task starts at %s after %d resumes", restarted.start, restarted.resumes)
+			}
+		})
+	}
+}
+
+func TestPlayerResume(t *testing.T) {
+	// the download error reaches the player through the decoder
+	downloadErr := fmt.Errorf("decoder: %w", wrapDownloadError(errors.New("HTTP Error 403: Forbidden")))
+
+	tests := []struct {
+		name       string
+		source     string
+		err        error
+		refreshErr error
+		resumes    int
+		want       bool
+	}{
+		{name: "download failed", source: "fake", err: downloadErr, want: true},
+		{name: "resumed once", source: "fake", err: downloadErr, resumes: maxResumes - 1, want: true},
+		{name: "too many resumes", source: "fake", err: downloadErr, resumes: maxResumes},
+		{name: "finished", source: "fake", err: nil},
+		{name: "skipped", source: "fake", err: errTrackSkipped},
+		{name: "voice failed", source: "fake", err: errors.New("sender: voice connection is closed")},
+		{name: "decoder failed", source: "fake", err: errors.New("decoder: invalid version")},
+		{name: "refresh failed", source: "fake", err: downloadErr, refreshErr: errors.New("video unavailable")},
+		{name: "source without refresh", source: "static", err: downloadErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &fakeSource{err: tt.refreshErr}
+			p := &Player{
+				sources: map[string]Source{"fake": source, "static": staticSource{}},
+				log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			p.elapsed.Store(int64(42 * time.Second))
+			task := &Task{track: &Track{Source: tt.source, URL: "https://example.com/a"}, start: 10 * time.Second, resumes: tt.resumes}
+
+			resumed := p.resume(context.Background(), p.log, task, tt.err)
+			if (resumed != nil) != tt.want {
+				t.Fatalf("got resumed %v, want %v", resumed != nil, tt.want)
+			}
+			if !tt.want {
+				if source.refreshes != 0 && tt.refreshErr == nil {
+					t.Errorf("the track is refreshed %d times without resuming", source.refreshes)
+				}
+				return
+			}
+			if resumed.track.URL != "https://example.com/a#1" {
+				t.Errorf("got track %s, want the refreshed one", resumed.track.URL)
+			}
+			if resumed.start != 42*time.Second {
+				t.Errorf("got start %s, want the failure position", resumed.start)
+			}
+			if resumed.resumes != tt.resumes+1 {
+				t.Errorf("got %d resumes, want %d", resumed.resumes, tt.resumes+1)
+			}
+		})
+	}
+
+	p := &Player{sources: map[string]Source{"fake": &fakeSource{}}, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if resumed := p.resume(ctx, p.log, &Task{track: &Track{Source: "fake"}}, downloadErr); resumed != nil {
+		t.Error("the track is resumed after the player is stopped")
+	}
+}
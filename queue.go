@@ -2,55 +2,147 @@ package discobot
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrQueueFull     = errors.New("queue is full")
+	ErrQueuePosition = errors.New("position is out of the queue")
 )
 
 type Queue[T any] struct {
-	playQueue chan T
-	capacity  int
+	mu       sync.Mutex
+	items    []T
+	capacity int
+	notify   chan struct{}
 }
 
-func NewQueue[T any](capacity int) Queue[T] {
-	queue := Queue[T]{capacity: capacity}
-	queue.Clean()
-	return queue
+func NewQueue[T any](capacity int) *Queue[T] {
+	return &Queue[T]{
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
 }
 
 func (pq *Queue[T]) Push(item T) error {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if len(pq.items) >= pq.capacity {
+		return ErrQueueFull
+	}
+	pq.items = append(pq.items, item)
+	pq.notifyConsumer()
+	return nil
+}
+
+// notifyConsumer wakes up Pop, the lock must be held.
+func (pq *Queue[T]) notifyConsumer() {
 	select {
-	case pq.playQueue <- item:
+	case pq.notify <- struct{}{}:
 	default:
-		return fmt.Errorf("queue is full")
+		// Skip if the consumer is already notified
 	}
-	return nil
+}
+
+// ForcePush adds the item even if the queue is full, e.g. to put back the item taken from the queue.
+func (pq *Queue[T]) ForcePush(item T) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.items = append(pq.items, item)
+	pq.notifyConsumer()
 }
 
 func (pq *Queue[T]) Pop(ctx context.Context) (T, error) {
 	for {
+		if item, ok := pq.tryPop(); ok {
+			return item, nil
+		}
+
 		select {
 		case <-ctx.Done():
 			var empty T
 			return empty, ctx.Err()
+		case <-pq.notify:
+		}
+	}
+}
 
-		case item, ok := <-pq.playQueue:
-			if !ok {
-				continue
-			}
+func (pq *Queue[T]) tryPop() (T, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
 
-			return item, nil
-		}
+	var empty T
+	if len(pq.items) == 0 {
+		return empty, false
+	}
+
+	item := pq.items[0]
+	pq.items[0] = empty
+	pq.items = pq.items[1:]
+	return item, true
+}
+
+// Peek returns the first item without removing it.
+func (pq *Queue[T]) Peek() (T, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	var empty T
+	if len(pq.items) == 0 {
+		return empty, false
 	}
+	return pq.items[0], true
 }
 
 func (pq *Queue[T]) Clean() {
-	oldChan := pq.playQueue
-	pq.playQueue = make(chan T, pq.capacity)
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.items = nil
+}
 
-	if oldChan != nil {
-		close(oldChan)
+// Move moves the item from one position to another, the items between them are shifted.
+func (pq *Queue[T]) Move(from, to int) error {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if from < 0 || from >= len(pq.items) || to < 0 || to >= len(pq.items) {
+		return ErrQueuePosition
 	}
+
+	item := pq.items[from]
+	if from < to {
+		copy(pq.items[from:to], pq.items[from+1:to+1])
+	} else {
+		copy(pq.items[to+1:from+1], pq.items[to:from])
+	}
+	pq.items[to] = item
+	return nil
 }
 
 func (pq *Queue[T]) Len() int {
-	return len(pq.playQueue)
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	return len(pq.items)
+}
+
+// SetCapacity changes the maximum number of items.
+// Items that are already in the queue are kept even if they don't fit.
+func (pq *Queue[T]) SetCapacity(capacity int) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.capacity = capacity
+}
+
+// Items returns a copy of the queued items.
+func (pq *Queue[T]) Items() []T {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	return append([]T(nil), pq.items...)
 }
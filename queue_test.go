@@ -0,0 +1,152 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestQueue(capacity int, items ...int) *Queue[int] {
+	q := NewQueue[int](capacity)
+	for _, item := range items {
+		q.ForcePush(item)
+	}
+	return q
+}
+
+func TestQueuePush(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		items    []int
+		force    bool
+		want     []int
+		err      error
+	}{
+		{name: "empty", capacity: 2, want: []int{9}},
+		{name: "not full", capacity: 2, items: []int{1}, want: []int{1, 9}},
+		{name: "full", capacity: 2, items: []int{1, 2}, want: []int{1, 2}, err: ErrQueueFull},
+		{name: "over capacity", capacity: 1, items: []int{1, 2}, want: []int{1, 2}, err: ErrQueueFull},
+		{name: "force on full", capacity: 2, items: []int{1, 2}, force: true, want: []int{1, 2, 9}},
+		{name: "force on zero capacity", capacity: 0, force: true, want: []int{9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(tt.capacity, tt.items...)
+
+			var err error
+			if tt.force {
+				q.ForcePush(9)
+			} else {
+				err = q.Push(9)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got := q.Items(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got items %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueMove(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+		want     []int
+		err      error
+	}{
+		{name: "forward", from: 0, to: 2, want: []int{2, 3, 1, 4}},
+		{name: "backward", from: 3, to: 1, want: []int{1, 4, 2, 3}},
+		{name: "same position", from: 2, to: 2, want: []int{1, 2, 3, 4}},
+		{name: "to the end", from: 0, to: 3, want: []int{2, 3, 4, 1}},
+		{name: "to the front", from: 3, to: 0, want: []int{4, 1, 2, 3}},
+		{name: "negative from", from: -1, to: 0, err: ErrQueuePosition},
+		{name: "negative to", from: 0, to: -1, err: ErrQueuePosition},
+		{name: "from after the end", from: 4, to: 0, err: ErrQueuePosition},
+		{name: "to after the end", from: 0, to: 4, err: ErrQueuePosition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(10, 1, 2, 3, 4)
+
+			err := q.Move(tt.from, tt.to)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			want := tt.want
+			if tt.err != nil {
+				want = []int{1, 2, 3, 4}
+			}
+			if got := q.Items(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got items %v, want %v", got, want)
+			}
+		})
+	}
+
+	if err := NewQueue[int](1).Move(0, 0); !errors.Is(err, ErrQueuePosition) {
+		t.Errorf("got error %v for the empty queue, want %v", err, ErrQueuePosition)
+	}
+}
+
+func TestQueuePopWakeUp(t *testing.T) {
+	tests := []struct {
+		name string
+		push func(q *Queue[int])
+	}{
+		{name: "push", push: func(q *Queue[int]) { _ = q.Push(1) }},
+		{name: "force push", push: func(q *Queue[int]) { q.ForcePush(1) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue[int](1)
+
+			type result struct {
+				item int
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				item, err := q.Pop(context.Background())
+				done <- result{item, err}
+			}()
+
+			time.Sleep(10 * time.Millisecond)
+			tt.push(q)
+
+			select {
+			case r := <-done:
+				if r.err != nil || r.item != 1 {
+					t.Errorf("got %d, %v, want 1", r.item, r.err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Pop isn't woken up by the pushed item")
+			}
+			if q.Len() != 0 {
+				t.Errorf("got %d items after Pop, want 0", q.Len())
+			}
+		})
+	}
+}
+
+func TestQueuePopCanceled(t *testing.T) {
+	q := NewQueue[int](1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the item pushed after the canceled Pop is kept
+	_ = q.Push(1)
+	if item, err := q.Pop(context.Background()); err != nil || item != 1 {
+		t.Errorf("got %d, %v, want 1", item, err)
+	}
+}
@@ -0,0 +1,682 @@
+package discobot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	dg "github.com/andersfylling/disgord"
+	"golang.org/x/exp/slog"
+)
+
+// autocompleteTimeout is the time Discord waits for autocomplete choices.
+const autocompleteTimeout = 3 * time.Second
+
+// replyTimeout limits the time of sending a reply. Replies don't use the command context,
+// so the errors of timed out commands are still reported.
+const replyTimeout = 10 * time.Second
+
+type permission int
+
+const (
+	everyone permission = iota
+	// djOnly allows the command to DJs and the server owner.
+	djOnly
+)
+
+// command is a slash command, a subcommand or a group of subcommands.
+// Handlers are methods of DiscoBot, so commands don't depend on a bot instance
+// and the same definitions are used for the registration.
+type command struct {
+	name        string
+	description string
+	permission  permission
+	subcommands []*command
+
+	options []*dg.ApplicationCommandOption
+	handle  func(bot *DiscoBot, c *commandContext, options []*dg.ApplicationCommandDataOption) error
+	// autocomplete returns the choices for the partial value of the option with autocomplete.
+	autocomplete autocompleter
+	// deferred commands are acknowledged before they are handled, as Discord waits
+	// only 3 seconds for the reply, e.g. resolving a track may take longer.
+	deferred bool
+}
+
+type autocompleter func(bot *DiscoBot, c *commandContext, value string) ([]*dg.ApplicationCommandOptionChoice, error)
+
+// newCommand creates a command with options declared by the fields of T.
+// Each field is tagged with the option name and description:
+//
+//	URL string `option:"url" description:"YouTube video URL" required:"true"`
+//
+// Supported field types are string, int, float64, bool and dg.Snowflake with the type tag:
+// role, user, channel or text_channel. Numeric options accept min and max tags,
+// string options accept comma separated choices or autocomplete:"true" with the choices
+// returned by the function set with withAutocomplete. If T has a Validate() error method,
+// it is called after decoding.
+func newCommand[T any](name, description string, handler func(bot *DiscoBot, c *commandContext, opts T) error) *command {
+	fields := optionFields(reflect.TypeOf((*T)(nil)).Elem())
+
+	var options []*dg.ApplicationCommandOption
+	for _, field := range fields {
+		options = append(options, field.spec)
+	}
+
+	return &command{
+		name:        name,
+		description: description,
+		options:     options,
+		handle: func(bot *DiscoBot, c *commandContext, data []*dg.ApplicationCommandDataOption) error {
+			var opts T
+			if err := decodeOptions(fields, data, reflect.ValueOf(&opts).Elem()); err != nil {
+				return err
+			}
+			if v, ok := any(&opts).(interface{ Validate() error }); ok {
+				if err := v.Validate(); err != nil {
+					return &optionError{err: err}
+				}
+			}
+			return handler(bot, c, opts)
+		},
+	}
+}
+
+func newGroup(name, description string, subcommands ...*command) *command {
+	return &command{name: name, description: description, subcommands: subcommands}
+}
+
+func (cmd *command) withPermission(p permission) *command {
+	cmd.permission = p
+	return cmd
+}
+
+func (cmd *command) withAutocomplete(fn autocompleter) *command {
+	cmd.autocomplete = fn
+	return cmd
+}
+
+func (cmd *command) withDeferredReply() *command {
+	cmd.deferred = true
+	return cmd
+}
+
+// applicationCommand is the command payload with the fields disgord doesn't have.
+type applicationCommand struct {
+	dg.CreateApplicationCommand
+	// DMPermission is false, the commands need a server.
+	DMPermission bool `json:"dm_permission"`
+}
+
+func (cmd *command) applicationCommand() *applicationCommand {
+	return &applicationCommand{
+		CreateApplicationCommand: dg.CreateApplicationCommand{
+			Name:        cmd.name,
+			Description: cmd.description,
+			Options:     cmd.applicationOptions(),
+		},
+	}
+}
+
+func (cmd *command) applicationOptions() []*dg.ApplicationCommandOption {
+	if len(cmd.subcommands) == 0 {
+		return cmd.options
+	}
+
+	options := make([]*dg.ApplicationCommandOption, len(cmd.subcommands))
+	for i, sub := range cmd.subcommands {
+		optionType := dg.OptionTypeSubCommand
+		if len(sub.subcommands) != 0 {
+			optionType = dg.OptionTypeSubCommandGroup
+		}
+		options[i] = &dg.ApplicationCommandOption{
+			Type:        optionType,
+			Name:        sub.name,
+			Description: sub.description,
+			Options:     sub.applicationOptions(),
+		}
+	}
+	return options
+}
+
+func (cmd *command) option(name string) *dg.ApplicationCommandOption {
+	for _, option := range cmd.options {
+		if option.Name == name {
+			return option
+		}
+	}
+	return nil
+}
+
+func (cmd *command) subcommand(name string) *command {
+	for _, sub := range cmd.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+type router struct {
+	commands []*command
+}
+
+func newRouter(commands ...*command) *router {
+	return &router{commands: commands}
+}
+
+func (r *router) applicationCommands() []*applicationCommand {
+	commands := make([]*applicationCommand, len(r.commands))
+	for i, cmd := range r.commands {
+		commands[i] = cmd.applicationCommand()
+	}
+	return commands
+}
+
+// resolve finds the invoked command and its options.
+// The permission of a subcommand is the strictest one along its path.
+func (r *router) resolve(data *dg.ApplicationCommandInteractionData) (*command, permission, []*dg.ApplicationCommandDataOption, error) {
+	var cmd *command
+	for _, c := range r.commands {
+		if c.name == data.Name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		return nil, 0, nil, fmt.Errorf("unknown command: %s", data.Name)
+	}
+
+	perm := cmd.permission
+	options := data.Options
+	for len(cmd.subcommands) != 0 {
+		if len(options) != 1 {
+			return nil, 0, nil, fmt.Errorf("subcommand of %s is not provided", cmd.name)
+		}
+		option := options[0]
+		if option.Type != dg.OptionTypeSubCommand && option.Type != dg.OptionTypeSubCommandGroup {
+			return nil, 0, nil, fmt.Errorf("subcommand of %s is not provided", cmd.name)
+		}
+
+		sub := cmd.subcommand(option.Name)
+		if sub == nil {
+			return nil, 0, nil, fmt.Errorf("unknown subcommand: %s %s", cmd.name, option.Name)
+		}
+		cmd = sub
+		options = option.Options
+		if cmd.permission > perm {
+			perm = cmd.permission
+		}
+	}
+
+	return cmd, perm, options, nil
+}
+
+// handle runs the command of the interaction. Panics of handlers are recovered,
+// invalid options and denied permissions are reported to the user.
+func (r *router) handle(bot *DiscoBot, s dg.Session, i *dg.InteractionCreate) {
+	if i.Data == nil {
+		return
+	}
+	if i.Type == dg.InteractionApplicationCommandAutocomplete {
+		r.autocomplete(bot, s, i)
+		return
+	}
+	if i.Type != dg.InteractionApplicationCommand {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bot.commandTimeout)
+	defer cancel()
+
+	c := &commandContext{
+		Context:     ctx,
+		session:     s,
+		interaction: i,
+		log:         logger.With("guild", i.GuildID, "user", interactionUserID(i), "command", commandPath(i.Data)),
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			c.log.Error("command panicked", "panic", p, "stack", string(debug.Stack()))
+			c.replyError("Something went wrong")
+		}
+	}()
+
+	// Commands are registered globally without DM permission, interactions from DMs can still arrive.
+	if i.GuildID.IsZero() || i.Member == nil {
+		c.log.Info("command is used outside of a server")
+		c.replyError("Commands can only be used in a server")
+		return
+	}
+
+	cmd, perm, options, err := r.resolve(i.Data)
+	if err != nil {
+		c.log.Error("failed to resolve the command", "err", err)
+		c.replyError("Unknown command")
+		return
+	}
+
+	if perm == djOnly {
+		allowed, err := bot.isPrivileged(i.GuildID, i.Member)
+		if err != nil {
+			c.log.Error("failed to check permissions", "err", err)
+			c.replyError("Something went wrong")
+			return
+		}
+		if !allowed {
+			c.log.Info("command is denied")
+			c.replyError("Only DJs and the server owner can do that")
+			return
+		}
+	}
+
+	if cmd.deferred {
+		if err := c.deferReply(); err != nil {
+			c.log.Error("failed to defer the reply", "err", err)
+			return
+		}
+	}
+
+	err = cmd.handle(bot, c, options)
+
+	var optErr *optionError
+	if errors.As(err, &optErr) {
+		c.replyError(optErr.Error())
+		return
+	}
+	if msg, ok := UserMessage(err); ok {
+		c.log.Info("command rejected", "err", err)
+		c.replyError(msg)
+		return
+	}
+	if err != nil {
+		c.log.Error("command failed", "err", err)
+		c.replyError("Something went wrong")
+	}
+}
+
+// autocomplete responds with the choices for the option the user is typing.
+// Permissions aren't checked as the choices are only suggestions.
+func (r *router) autocomplete(bot *DiscoBot, s dg.Session, i *dg.InteractionCreate) {
+	ctx, cancel := context.WithTimeout(context.Background(), autocompleteTimeout)
+	defer cancel()
+
+	c := &commandContext{
+		Context:     ctx,
+		session:     s,
+		interaction: i,
+		log:         logger.With("guild", i.GuildID, "user", interactionUserID(i), "command", commandPath(i.Data)),
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			c.log.Error("autocomplete panicked", "panic", p, "stack", string(debug.Stack()))
+		}
+	}()
+
+	cmd, _, options, err := r.resolve(i.Data)
+	if err != nil {
+		c.log.Error("failed to resolve the command", "err", err)
+		return
+	}
+	if cmd.autocomplete == nil {
+		c.log.Error("the command has no autocomplete")
+		return
+	}
+
+	var value string
+	for _, option := range options {
+		if spec := cmd.option(option.Name); spec != nil && spec.Autocomplete {
+			value, _ = option.Value.(string)
+		}
+	}
+
+	choices, err := cmd.autocomplete(bot, c, value)
+	if err != nil {
+		c.log.Error("autocomplete failed", "err", err)
+	}
+	if err := c.respondChoices(choices); err != nil {
+		c.log.Error("failed to respond with choices", "err", err)
+	}
+}
+
+// commandPath returns the name of the command with its subcommands, e.g. "disco queue list".
+func commandPath(data *dg.ApplicationCommandInteractionData) string {
+	path := data.Name
+	options := data.Options
+	for len(options) > 0 {
+		option := options[0]
+		if option.Type != dg.OptionTypeSubCommand && option.Type != dg.OptionTypeSubCommandGroup {
+			break
+		}
+		path += " " + option.Name
+		options = option.Options
+	}
+	return path
+}
+
+func interactionUserID(i *dg.InteractionCreate) dg.Snowflake {
+	if i.Member != nil {
+		return i.Member.UserID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return 0
+}
+
+type commandContext struct {
+	context.Context
+	session     dg.Session
+	interaction *dg.InteractionCreate
+	log         *slog.Logger
+	replied     bool
+	// deferred is set when the interaction is acknowledged and the reply has to edit the original response.
+	deferred bool
+}
+
+func (c *commandContext) respond(data *dg.CreateInteractionResponseData) error {
+	c.replied = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+
+	if c.deferred {
+		return c.respondDeferred(ctx, data)
+	}
+	return c.session.SendInteractionResponse(ctx, c.interaction, &dg.CreateInteractionResponse{
+		Type: dg.InteractionCallbackChannelMessageWithSource,
+		Data: data,
+	})
+}
+
+// deferReply acknowledges the interaction, users see that the bot is thinking until it replies.
+func (c *commandContext) deferReply() error {
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+
+	if err := c.session.SendInteractionResponse(ctx, c.interaction, &dg.CreateInteractionResponse{
+		Type: dg.InteractionCallbackDeferredChannelMessageWithSource,
+	}); err != nil {
+		return err
+	}
+	c.deferred = true
+	return nil
+}
+
+// respondDeferred replaces the original response of the deferred interaction. The original response
+// is visible to everyone, so ephemeral replies are sent as followups and the original one is deleted.
+func (c *commandContext) respondDeferred(ctx context.Context, data *dg.CreateInteractionResponseData) error {
+	if data.Flags&dg.MessageFlagEphemeral == 0 {
+		return c.session.EditInteractionResponse(ctx, c.interaction, &dg.UpdateMessage{Content: &data.Content})
+	}
+
+	webhook := fmt.Sprintf("/webhooks/%d/%s", c.interaction.ApplicationID, c.interaction.Token)
+	followup := struct {
+		Content string         `json:"content"`
+		Flags   dg.MessageFlag `json:"flags"`
+	}{Content: data.Content, Flags: data.Flags}
+	if err := c.request(ctx, http.MethodPost, webhook, followup); err != nil {
+		return err
+	}
+	return c.request(ctx, http.MethodDelete, webhook+"/messages/@original", nil)
+}
+
+// autocompleteResponse is the response to an autocomplete interaction, disgord doesn't support its data.
+type autocompleteResponse struct {
+	Type dg.InteractionCallbackType `json:"type"`
+	Data struct {
+		Choices []*dg.ApplicationCommandOptionChoice `json:"choices"`
+	} `json:"data"`
+}
+
+func (c *commandContext) respondChoices(choices []*dg.ApplicationCommandOptionChoice) error {
+	response := autocompleteResponse{Type: dg.InteractionCallbackApplicationCommandAutocompleteResult}
+	response.Data.Choices = choices
+	if response.Data.Choices == nil {
+		response.Data.Choices = []*dg.ApplicationCommandOptionChoice{}
+	}
+
+	endpoint := fmt.Sprintf("/interactions/%d/%s/callback", c.interaction.ID, c.interaction.Token)
+	return c.request(c, http.MethodPost, endpoint, response)
+}
+
+// request sends the request to the endpoint authorized by the interaction token.
+func (c *commandContext) request(ctx context.Context, method, endpoint string, data any) error {
+	var body io.Reader
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, discordAPI+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, msg)
+	}
+	return nil
+}
+
+func (c *commandContext) reply(content string) error {
+	return c.respond(&dg.CreateInteractionResponseData{Content: content})
+}
+
+// replyEphemeral replies with a message visible only to the user.
+func (c *commandContext) replyEphemeral(content string) error {
+	return c.respond(&dg.CreateInteractionResponseData{Content: content, Flags: dg.MessageFlagEphemeral})
+}
+
+// replyError replies with an ephemeral error message unless the handler already replied.
+func (c *commandContext) replyError(content string) {
+	if c.replied {
+		return
+	}
+	if err := c.replyEphemeral(content); err != nil {
+		c.log.Error("failed to reply", "err", err)
+	}
+}
+
+// optionError is an invalid option provided by the user.
+type optionError struct {
+	name string
+	err  error
+}
+
+func (e *optionError) Error() string {
+	if e.name == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("Invalid %s: %s", e.name, e.err)
+}
+
+func (e *optionError) Unwrap() error {
+	return e.err
+}
+
+type optionField struct {
+	index int
+	spec  *dg.ApplicationCommandOption
+}
+
+var snowflakeType = reflect.TypeOf(dg.Snowflake(0))
+
+// optionFields returns the options declared by the struct fields. It panics on invalid
+// declarations as commands are declared statically.
+func optionFields(t reflect.Type) []optionField {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("options must be a struct, got %s", t))
+	}
+
+	var fields []optionField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := f.Tag.Lookup("option")
+		if !ok {
+			continue
+		}
+
+		spec := &dg.ApplicationCommandOption{
+			Name:        name,
+			Description: f.Tag.Get("description"),
+			Required:    f.Tag.Get("required") == "true",
+		}
+
+		switch {
+		case f.Type == snowflakeType:
+			switch typ := f.Tag.Get("type"); typ {
+			case "role":
+				spec.Type = dg.OptionTypeRole
+			case "user":
+				spec.Type = dg.OptionTypeUser
+			case "channel":
+				spec.Type = dg.OptionTypeChannel
+			case "text_channel":
+				spec.Type = dg.OptionTypeChannel
+				spec.ChannelTypes = []dg.ChannelType{dg.ChannelTypeGuildText}
+			default:
+				panic(fmt.Sprintf("option %s: unknown snowflake type %q", name, typ))
+			}
+		case f.Type.Kind() == reflect.String:
+			spec.Type = dg.OptionTypeString
+			spec.Autocomplete = f.Tag.Get("autocomplete") == "true"
+			if choices := f.Tag.Get("choices"); choices != "" {
+				for _, choice := range strings.Split(choices, ",") {
+					spec.Choices = append(spec.Choices, &dg.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+				}
+			}
+		case f.Type.Kind() == reflect.Int:
+			spec.Type = dg.OptionTypeInteger
+		case f.Type.Kind() == reflect.Float64:
+			spec.Type = dg.OptionTypeNumber
+		case f.Type.Kind() == reflect.Bool:
+			spec.Type = dg.OptionTypeBoolean
+		default:
+			panic(fmt.Sprintf("option %s: unsupported type %s", name, f.Type))
+		}
+
+		if spec.Type == dg.OptionTypeInteger || spec.Type == dg.OptionTypeNumber {
+			spec.MinValue = mustParseFloatTag(f, "min")
+			spec.MaxValue = mustParseFloatTag(f, "max")
+		}
+
+		fields = append(fields, optionField{index: i, spec: spec})
+	}
+
+	return fields
+}
+
+func mustParseFloatTag(f reflect.StructField, key string) float64 {
+	tag, ok := f.Tag.Lookup(key)
+	if !ok {
+		panic(fmt.Sprintf("option %s: %s is not set", f.Name, key))
+	}
+	v, err := strconv.ParseFloat(tag, 64)
+	if err != nil {
+		panic(fmt.Sprintf("option %s: invalid %s: %s", f.Name, key, err))
+	}
+	return v
+}
+
+func decodeOptions(fields []optionField, data []*dg.ApplicationCommandDataOption, v reflect.Value) error {
+	values := make(map[string]any, len(data))
+	for _, option := range data {
+		values[option.Name] = option.Value
+	}
+
+	for _, field := range fields {
+		spec := field.spec
+		value, ok := values[spec.Name]
+		if !ok {
+			if spec.Required {
+				return &optionError{name: spec.Name, err: errors.New("the option is required")}
+			}
+			continue
+		}
+
+		if err := decodeOption(spec, value, v.Field(field.index)); err != nil {
+			return &optionError{name: spec.Name, err: err}
+		}
+	}
+
+	return nil
+}
+
+func decodeOption(spec *dg.ApplicationCommandOption, value any, field reflect.Value) error {
+	switch spec.Type {
+	case dg.OptionTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+		if len(spec.Choices) != 0 && !hasChoice(spec.Choices, s) {
+			return fmt.Errorf("unexpected value %q", s)
+		}
+		field.SetString(s)
+	case dg.OptionTypeInteger, dg.OptionTypeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("expected a number, got %T", value)
+		}
+		if n < spec.MinValue || n > spec.MaxValue {
+			return fmt.Errorf("must be between %v and %v", spec.MinValue, spec.MaxValue)
+		}
+		if spec.Type == dg.OptionTypeInteger {
+			if n != float64(int(n)) {
+				return errors.New("expected an integer")
+			}
+			field.SetInt(int64(n))
+		} else {
+			field.SetFloat(n)
+		}
+	case dg.OptionTypeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+		field.SetBool(b)
+	case dg.OptionTypeRole, dg.OptionTypeUser, dg.OptionTypeChannel:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected an ID, got %T", value)
+		}
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", s)
+		}
+		field.SetUint(id)
+	}
+
+	return nil
+}
+
+func hasChoice(choices []*dg.ApplicationCommandOptionChoice, value string) bool {
+	for _, choice := range choices {
+		if choice.Value == value {
+			return true
+		}
+	}
+	return false
+}
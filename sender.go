@@ -0,0 +1,252 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"discobot/metrics"
+)
+
+const (
+	// DefaultPreBuffer is the audio buffered before the player starts speaking.
+	DefaultPreBuffer = 200 * time.Millisecond
+
+	// trailingSilenceFrames are sent after the last track, so clients don't interpolate the last frames.
+	// https://discord.com/developers/docs/topics/voice-connections#voice-data-interpolation
+	trailingSilenceFrames = 5
+
+	// maxLag is the lag of the sender after which the clock is restarted instead of catching up
+	// with a burst of frames, e.g. after the voice connection was blocked.
+	maxLag = 5 * frameDuration
+)
+
+// silenceFrame is an Opus frame of silence.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// pacer schedules a frame every 20ms by the monotonic clock,
+// so the time it takes to decode and send a frame doesn't add up.
+type pacer struct {
+	start  time.Time
+	frames int64
+	timer  *time.Timer
+}
+
+func newPacer() *pacer {
+	timer := time.NewTimer(0)
+	<-timer.C
+	p := &pacer{timer: timer}
+	p.reset()
+	return p
+}
+
+// reset restarts the clock, the next frame is sent immediately.
+func (p *pacer) reset() {
+	p.start = time.Now()
+	p.frames = 0
+}
+
+// wait waits for the time of the next frame and returns how late it is.
+func (p *pacer) wait(ctx context.Context) (time.Duration, error) {
+	deadline := p.start.Add(time.Duration(p.frames) * frameDuration)
+	if d := time.Until(deadline); d > 0 {
+		p.timer.Reset(d)
+		select {
+		case <-ctx.Done():
+			if !p.timer.Stop() {
+				<-p.timer.C
+			}
+			return 0, ctx.Err()
+		case <-p.timer.C:
+		}
+	}
+
+	lateness := time.Since(deadline)
+	if lateness > maxLag {
+		p.reset()
+	}
+	p.frames++
+	return lateness, nil
+}
+
+// sender sends the decoded frames to the voice connection in real time. It buffers the audio
+// before speaking and fills underruns with silence until the buffer is filled again.
+// The speaking session and the clock continue from one track to the next one, so there is no gap.
+type sender struct {
+	voice    VoiceSink
+	playback *Playback
+	// prebuffer is the number of frames buffered before speaking and after underruns.
+	prebuffer int
+	clock     *pacer
+	speaking  bool
+}
+
+func newSender(voice VoiceSink, playback *Playback, prebuffer time.Duration) *sender {
+	return &sender{
+		voice:     voice,
+		playback:  playback,
+		prebuffer: int(prebuffer / frameDuration),
+		clock:     newPacer(),
+	}
+}
+
+// play sends the frames of the track until the channel is closed, onFrame is called after each frame.
+func (s *sender) play(ctx context.Context, packets <-chan []byte, onFrame func()) error {
+	track := &frameBuffer{packets: packets, size: s.prebuffer}
+
+	if !s.speaking {
+		if err := track.fill(ctx); err != nil {
+			return err
+		}
+		if track.empty() {
+			return nil
+		}
+
+		if err := s.voice.StartSpeaking(); err != nil {
+			return fmt.Errorf("sender: failed to start speaking: %w", err)
+		}
+		s.speaking = true
+		s.clock.reset()
+	}
+
+	// the next track is buffered while speaking if it isn't prefetched
+	buffering := true
+	for {
+		checkStart := time.Now()
+		if err := s.playback.Check(ctx); err != nil {
+			return err
+		}
+		// the clock is stopped while paused
+		if time.Since(checkStart) > frameDuration {
+			s.clock.reset()
+		}
+
+		lateness, err := s.clock.wait(ctx)
+		if err != nil {
+			return err
+		}
+		metrics.FrameJitter.Observe(lateness.Seconds())
+
+		track.poll()
+		if buffering && !track.full() && !track.closed {
+			if err := s.send(silenceFrame); err != nil {
+				return err
+			}
+			metrics.SilenceFrames.Inc()
+			continue
+		}
+		buffering = false
+
+		frame, ok := track.next()
+		if !ok {
+			if track.closed {
+				return nil
+			}
+			metrics.Underruns.Inc()
+			buffering = true
+			if err := s.send(silenceFrame); err != nil {
+				return err
+			}
+			metrics.SilenceFrames.Inc()
+			continue
+		}
+
+		if err := s.send(frame); err != nil {
+			return err
+		}
+		metrics.OpusFramesSent.Inc()
+		onFrame()
+	}
+}
+
+// stop ends the speaking session after the last track.
+func (s *sender) stop(ctx context.Context) error {
+	if !s.speaking {
+		return nil
+	}
+	s.speaking = false
+
+	err := s.sendTrailingSilence(ctx)
+	if stopErr := s.voice.StopSpeaking(); stopErr != nil {
+		err = errors.Join(err, fmt.Errorf("sender: failed to stop speaking: %w", stopErr))
+	}
+	return err
+}
+
+func (s *sender) sendTrailingSilence(ctx context.Context) error {
+	for i := 0; i < trailingSilenceFrames; i++ {
+		if _, err := s.clock.wait(ctx); err != nil {
+			return err
+		}
+		if err := s.send(silenceFrame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sender) send(frame []byte) error {
+	if err := s.voice.SendOpusFrame(frame); err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	return nil
+}
+
+// frameBuffer takes the decoded frames of a track up to its size.
+type frameBuffer struct {
+	packets <-chan []byte
+	size    int
+	frames  [][]byte
+	closed  bool
+}
+
+func (b *frameBuffer) empty() bool {
+	return len(b.frames) == 0
+}
+
+func (b *frameBuffer) full() bool {
+	return len(b.frames) >= b.size && !b.empty()
+}
+
+// fill waits until the buffer is full or the track is decoded.
+func (b *frameBuffer) fill(ctx context.Context) error {
+	for !b.closed && !b.full() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case packet, ok := <-b.packets:
+			if !ok {
+				b.closed = true
+				break
+			}
+			b.frames = append(b.frames, packet)
+		}
+	}
+	return nil
+}
+
+// poll takes the decoded frames without waiting.
+func (b *frameBuffer) poll() {
+	for !b.closed && !b.full() {
+		select {
+		case packet, ok := <-b.packets:
+			if !ok {
+				b.closed = true
+				return
+			}
+			b.frames = append(b.frames, packet)
+		default:
+			return
+		}
+	}
+}
+
+func (b *frameBuffer) next() ([]byte, bool) {
+	if b.empty() {
+		return nil, false
+	}
+	frame := b.frames[0]
+	b.frames = b.frames[1:]
+	return frame, true
+}
@@ -0,0 +1,256 @@
+package discobot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testFrame(i int) []byte {
+	return []byte{0xFC, byte(i)}
+}
+
+func playingPlayback() *Playback {
+	playback := NewPlayback()
+	playback.StartCurrentTrack()
+	return &playback
+}
+
+var errSpeaking = errors.New("speaking update failed")
+
+// speakingErrorSink fails the speaking updates.
+type speakingErrorSink struct {
+	MemorySink
+	start, stop bool
+}
+
+func (s *speakingErrorSink) StartSpeaking() error {
+	if s.start {
+		return errSpeaking
+	}
+	return s.MemorySink.StartSpeaking()
+}
+
+func (s *speakingErrorSink) StopSpeaking() error {
+	if s.stop {
+		return errSpeaking
+	}
+	return s.MemorySink.StopSpeaking()
+}
+
+// audioFrames returns the frames except silence.
+func audioFrames(frames [][]byte) [][]byte {
+	var audio [][]byte
+	for _, frame := range frames {
+		if !bytes.Equal(frame, silenceFrame) {
+			audio = append(audio, frame)
+		}
+	}
+	return audio
+}
+
+func TestSenderPlaysFramesInRealTime(t *testing.T) {
+	sink := &MemorySink{}
+	s := newSender(sink, playingPlayback(), 3*frameDuration)
+
+	const count = 10
+	packets := make(chan []byte, count)
+	for i := 0; i < count; i++ {
+		packets <- testFrame(i)
+	}
+	close(packets)
+
+	played := 0
+	start := time.Now()
+	if err := s.play(context.Background(), packets, func() { played++ }); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if !sink.Speaking() {
+		t.Error("the sink isn't speaking after the track")
+	}
+	if err := s.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Speaking() {
+		t.Error("the sink is speaking after stop")
+	}
+
+	frames := sink.Frames()
+	if len(frames) != count+trailingSilenceFrames {
+		t.Fatalf("got %d frames, want %d", len(frames), count+trailingSilenceFrames)
+	}
+	for i, frame := range frames[:count] {
+		if !bytes.Equal(frame, testFrame(i)) {
+			t.Errorf("frame %d: got %v, want %v", i, frame, testFrame(i))
+		}
+	}
+	for _, frame := range frames[count:] {
+		if !bytes.Equal(frame, silenceFrame) {
+			t.Errorf("got trailing frame %v, want silence", frame)
+		}
+	}
+	if played != count {
+		t.Errorf("onFrame is called %d times, want %d", played, count)
+	}
+	// the first frame is sent immediately
+	if want := (count - 1) * frameDuration; elapsed < want {
+		t.Errorf("frames are sent in %s, want at least %s", elapsed, want)
+	}
+}
+
+func TestSenderFillsUnderrunsWithSilence(t *testing.T) {
+	sink := &MemorySink{}
+	s := newSender(sink, playingPlayback(), 2*frameDuration)
+
+	packets := make(chan []byte)
+	go func() {
+		defer close(packets)
+		for i := 0; i < 6; i++ {
+			if i == 3 {
+				// the decoder stalls
+				time.Sleep(10 * frameDuration)
+			}
+			packets <- testFrame(i)
+		}
+	}()
+
+	if err := s.play(context.Background(), packets, func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := sink.Frames()
+	audio := audioFrames(frames)
+	if len(audio) != 6 {
+		t.Fatalf("got %d frames of audio, want 6", len(audio))
+	}
+	for i, frame := range audio {
+		if !bytes.Equal(frame, testFrame(i)) {
+			t.Errorf("frame %d: got %v, want %v", i, frame, testFrame(i))
+		}
+	}
+
+	// silence is sent after the third frame until the buffer is filled again
+	silence := 0
+	for i, frame := range frames {
+		if bytes.Equal(frame, silenceFrame) {
+			silence++
+			continue
+		}
+		if bytes.Equal(frame, testFrame(3)) {
+			if silence == 0 {
+				t.Error("no silence before the frame after the stall")
+			}
+			if i < 3+silence {
+				t.Errorf("the frame after the stall is sent at %d", i)
+			}
+		}
+	}
+	if silence < 5 {
+		t.Errorf("got %d frames of silence during the stall of 10 frames", silence)
+	}
+}
+
+func TestSenderStopsOnSkip(t *testing.T) {
+	sink := &MemorySink{}
+	playback := playingPlayback()
+	s := newSender(sink, playback, frameDuration)
+
+	// the track never ends
+	packets := make(chan []byte)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case packets <- testFrame(i):
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	time.AfterFunc(5*frameDuration, playback.Skip)
+	err := s.play(context.Background(), packets, func() {})
+	if !errors.Is(err, errTrackSkipped) {
+		t.Fatalf("got error %v, want %v", err, errTrackSkipped)
+	}
+	if n := len(sink.Frames()); n == 0 || n > 10 {
+		t.Errorf("got %d frames before the skip after 5 frames", n)
+	}
+}
+
+func TestSenderPausesTheClock(t *testing.T) {
+	sink := &MemorySink{}
+	playback := playingPlayback()
+	s := newSender(sink, playback, frameDuration)
+
+	packets := make(chan []byte, 4)
+	for i := 0; i < 4; i++ {
+		packets <- testFrame(i)
+	}
+	close(packets)
+
+	playback.Pause()
+	time.AfterFunc(5*frameDuration, playback.Resume)
+
+	start := time.Now()
+	if err := s.play(context.Background(), packets, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	// frames aren't sent in a burst to catch up after the pause
+	if elapsed, want := time.Since(start), (5+3)*frameDuration; elapsed < want {
+		t.Errorf("the track is played in %s with the pause, want at least %s", elapsed, want)
+	}
+	if frames := audioFrames(sink.Frames()); len(frames) != 4 {
+		t.Errorf("got %d frames, want 4", len(frames))
+	}
+}
+
+func TestSenderCanceled(t *testing.T) {
+	sink := &MemorySink{}
+	s := newSender(sink, playingPlayback(), 3*frameDuration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(2*frameDuration, cancel)
+
+	// the buffer is never filled
+	packets := make(chan []byte)
+	if err := s.play(ctx, packets, func() {}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if sink.Speaking() || len(sink.Frames()) != 0 {
+		t.Error("the sink is used before the buffer is filled")
+	}
+}
+
+func TestSenderSpeakingErrors(t *testing.T) {
+	packets := make(chan []byte, 1)
+	packets <- testFrame(0)
+	close(packets)
+
+	sink := &speakingErrorSink{start: true}
+	s := newSender(sink, playingPlayback(), frameDuration)
+	if err := s.play(context.Background(), packets, func() {}); !errors.Is(err, errSpeaking) {
+		t.Fatalf("got error %v, want %v", err, errSpeaking)
+	}
+	if n := len(sink.Frames()); n != 0 {
+		t.Errorf("got %d frames without speaking", n)
+	}
+
+	packets = make(chan []byte, 1)
+	packets <- testFrame(0)
+	close(packets)
+
+	sink = &speakingErrorSink{stop: true}
+	s = newSender(sink, playingPlayback(), frameDuration)
+	if err := s.play(context.Background(), packets, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.stop(context.Background()); !errors.Is(err, errSpeaking) {
+		t.Fatalf("got error %v, want %v", err, errSpeaking)
+	}
+	if n := len(sink.Frames()); n != 1+trailingSilenceFrames {
+		t.Errorf("got %d frames, want the trailing silence before stopping", n)
+	}
+}
@@ -0,0 +1,60 @@
+package settings
+
+import (
+	"encoding/json"
+
+	dg "github.com/andersfylling/disgord"
+	bolt "go.etcd.io/bbolt"
+)
+
+var guildsBucket = []byte("guilds")
+
+// BoltStore keeps settings in an embedded bbolt database.
+type BoltStore struct {
+	db       *bolt.DB
+	defaults Guild
+}
+
+func OpenBoltStore(path string, defaults Guild) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(guildsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db, defaults: defaults}, nil
+}
+
+func (s *BoltStore) Get(guildID dg.Snowflake) (Guild, error) {
+	settings := s.defaults
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(guildsBucket).Get([]byte(guildID.String()))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &settings)
+	})
+	return settings, err
+}
+
+func (s *BoltStore) Put(guildID dg.Snowflake, settings Guild) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(guildsBucket).Put([]byte(guildID.String()), data)
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// JSONStore keeps settings of all guilds in a single JSON file
+// which is rewritten on every change.
+type JSONStore struct {
+	mu       sync.RWMutex
+	path     string
+	defaults Guild
+	guilds   map[dg.Snowflake]Guild
+}
+
+func OpenJSONStore(path string, defaults Guild) (*JSONStore, error) {
+	s := &JSONStore{path: path, defaults: defaults, guilds: make(map[dg.Snowflake]Guild)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[dg.Snowflake]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for guildID, rawSettings := range raw {
+		settings := defaults
+		if err := json.Unmarshal(rawSettings, &settings); err != nil {
+			return nil, err
+		}
+		s.guilds[guildID] = settings
+	}
+
+	return s, nil
+}
+
+func (s *JSONStore) Get(guildID dg.Snowflake) (Guild, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if g, ok := s.guilds[guildID]; ok {
+		return g, nil
+	}
+	return s.defaults, nil
+}
+
+func (s *JSONStore) Put(guildID dg.Snowflake, settings Guild) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, found := s.guilds[guildID]
+	s.guilds[guildID] = settings
+	if err := s.flush(); err != nil {
+		// the settings in memory are kept the same as in the file
+		if found {
+			s.guilds[guildID] = prev
+		} else {
+			delete(s.guilds, guildID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+// flush atomically replaces the file with the current settings.
+func (s *JSONStore) flush() error {
+	data, err := json.MarshalIndent(s.guilds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
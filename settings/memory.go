@@ -0,0 +1,40 @@
+package settings
+
+import (
+	"sync"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// MemoryStore keeps settings only for the lifetime of the process.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	defaults Guild
+	guilds   map[dg.Snowflake]Guild
+}
+
+func NewMemoryStore(defaults Guild) *MemoryStore {
+	return &MemoryStore{defaults: defaults, guilds: make(map[dg.Snowflake]Guild)}
+}
+
+func (s *MemoryStore) Get(guildID dg.Snowflake) (Guild, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if g, ok := s.guilds[guildID]; ok {
+		return g, nil
+	}
+	return s.defaults, nil
+}
+
+func (s *MemoryStore) Put(guildID dg.Snowflake, settings Guild) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.guilds[guildID] = settings
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package settings stores per-guild configuration of the bot.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+type LoopMode string
+
+const (
+	LoopOff   LoopMode = "off"
+	LoopTrack LoopMode = "track"
+	LoopQueue LoopMode = "queue"
+)
+
+func ParseLoopMode(s string) (LoopMode, error) {
+	switch mode := LoopMode(s); mode {
+	case LoopOff, LoopTrack, LoopQueue:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid loop mode: %s", s)
+}
+
+const (
+	MaxVolume         = 200
+	MaxQueueLengthCap = 1000
+	// MaxCrossfade is the longest crossfade between tracks in seconds.
+	MaxCrossfade = 12
+)
+
+type Guild struct {
+	// Volume in percents, 100 keeps the original loudness.
+	Volume                int          `json:"volume"`
+	DJRoleID              dg.Snowflake `json:"dj_role_id,omitempty"`
+	LoopMode              LoopMode     `json:"loop_mode"`
+	MaxQueueLength        int          `json:"max_queue_length"`
+	AnnouncementChannelID dg.Snowflake `json:"announcement_channel_id,omitempty"`
+	// VoteSkipRatio is a fraction of listeners that have to vote to skip a track.
+	VoteSkipRatio float64 `json:"vote_skip_ratio"`
+	// Crossfade is the number of seconds the end of a track is mixed with the next one, 0 disables it.
+	Crossfade int `json:"crossfade,omitempty"`
+}
+
+func Default() Guild {
+	return Guild{
+		Volume:         100,
+		LoopMode:       LoopOff,
+		MaxQueueLength: 32,
+		VoteSkipRatio:  0.5,
+	}
+}
+
+func (g Guild) Validate() error {
+	if g.Volume < 1 || g.Volume > MaxVolume {
+		return fmt.Errorf("volume must be between 1 and %d", MaxVolume)
+	}
+	if _, err := ParseLoopMode(string(g.LoopMode)); err != nil {
+		return err
+	}
+	if g.MaxQueueLength < 1 || g.MaxQueueLength > MaxQueueLengthCap {
+		return fmt.Errorf("max queue length must be between 1 and %d", MaxQueueLengthCap)
+	}
+	if g.VoteSkipRatio <= 0 || g.VoteSkipRatio > 1 {
+		return errors.New("vote skip ratio must be in (0, 1]")
+	}
+	if g.Crossfade < 0 || g.Crossfade > MaxCrossfade {
+		return fmt.Errorf("crossfade must be between 0 and %d seconds", MaxCrossfade)
+	}
+	return nil
+}
+
+// Store persists guild settings. Get returns the defaults the store is opened with
+// for guilds without stored settings.
+type Store interface {
+	Get(guildID dg.Snowflake) (Guild, error)
+	Put(guildID dg.Snowflake, settings Guild) error
+	Close() error
+}
+
+// Open opens a store by the file extension: ".json" for a JSON file,
+// ".db" and ".bolt" for a bbolt database.
+func Open(path string, defaults Guild) (Store, error) {
+	switch filepath.Ext(path) {
+	case ".json":
+		return OpenJSONStore(path, defaults)
+	case ".db", ".bolt":
+		return OpenBoltStore(path, defaults)
+	}
+	return nil, fmt.Errorf("unsupported settings store: %s", path)
+}
@@ -0,0 +1,105 @@
+package discobot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// playerSnapshot is the state of a player which is restored after restart.
+type playerSnapshot struct {
+	Current  *taskSnapshot  `json:"current,omitempty"`
+	Position time.Duration  `json:"position,omitempty"`
+	Queue    []taskSnapshot `json:"queue"`
+}
+
+type taskSnapshot struct {
+	Track         *Track       `json:"track"`
+	ChannelID     dg.Snowflake `json:"channel_id"`
+	TextChannelID dg.Snowflake `json:"text_channel_id"`
+	RequesterID   dg.Snowflake `json:"requester_id"`
+}
+
+func newTaskSnapshot(task *Task) taskSnapshot {
+	return taskSnapshot{
+		Track:         task.track,
+		ChannelID:     task.channelID,
+		TextChannelID: task.textChannelID,
+		RequesterID:   task.requesterID,
+	}
+}
+
+// task returns nil if the snapshot has no track.
+func (ts taskSnapshot) task(guildID dg.Snowflake) *Task {
+	if ts.Track == nil {
+		return nil
+	}
+
+	return &Task{
+		track:         ts.Track,
+		guildID:       guildID,
+		channelID:     ts.ChannelID,
+		textChannelID: ts.TextChannelID,
+		requesterID:   ts.RequesterID,
+	}
+}
+
+// snapshotStore keeps a snapshot of each guild player in a separate file of the directory.
+type snapshotStore struct {
+	dir string
+}
+
+func (ss *snapshotStore) path(guildID dg.Snowflake) string {
+	return filepath.Join(ss.dir, guildID.String()+".json")
+}
+
+func (ss *snapshotStore) save(guildID dg.Snowflake, snapshot playerSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(ss.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(ss.dir, guildID.String()+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), ss.path(guildID))
+}
+
+// load returns false if there is no snapshot of the guild.
+func (ss *snapshotStore) load(guildID dg.Snowflake) (playerSnapshot, bool, error) {
+	var snapshot playerSnapshot
+
+	data, err := os.ReadFile(ss.path(guildID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshot, false, nil
+	}
+	if err != nil {
+		return snapshot, false, err
+	}
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, false, err
+	}
+
+	return snapshot, true, nil
+}
@@ -0,0 +1,88 @@
+package discobot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Source resolves tracks and opens their audio, e.g. yt-dlp, local files or internet radio.
+type Source interface {
+	// Name identifies the source of tracks, e.g. in snapshots.
+	Name() string
+	// Resolve returns the track of the query, e.g. URL.
+	Resolve(ctx context.Context, query string) (*Track, error)
+	// Open returns the Ogg Opus or WebM Opus stream of the track. The stream is stopped on close or when the context is done,
+	// errors of the source are returned by Read.
+	Open(ctx context.Context, track *Track, opts OpenOptions) (io.ReadCloser, error)
+}
+
+// refresher is implemented by sources whose tracks expire, e.g. the stream URLs of yt-dlp.
+// The player refreshes the track and resumes it if it fails in the middle.
+type refresher interface {
+	Refresh(ctx context.Context, track *Track) (*Track, error)
+}
+
+// Track is a resolved track of a source.
+type Track struct {
+	// Source is the name of the source which resolved the track.
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	// Duration is the length of the track, it is 0 if it is unknown, e.g. for live streams.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Seekable reports whether the track can be opened from a position.
+	Seekable bool `json:"seekable,omitempty"`
+	// Data is the source specific data, it is saved with the track in snapshots.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type OpenOptions struct {
+	// Start is the position to start from, it is ignored for tracks which aren't seekable.
+	Start time.Duration
+	// Volume is a multiplier of the output loudness, 0 and 1 keep the original one.
+	Volume float64
+	Logger *slog.Logger
+}
+
+// source returns the source of the track.
+func (bot *DiscoBot) source(name string) (Source, error) {
+	source, ok := bot.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", name)
+	}
+	return source, nil
+}
+
+// pipeStream is the stream written by a goroutine, closing it stops the goroutine and waits for it.
+type pipeStream struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newPipeStream runs write in a goroutine, its error is returned by Read after the written data.
+func newPipeStream(ctx context.Context, write func(ctx context.Context, w io.WriteCloser) error) *pipeStream {
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	s := &pipeStream{PipeReader: r, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		err := write(ctx, w)
+		w.CloseWithError(err)
+	}()
+
+	return s
+}
+
+func (s *pipeStream) Close() error {
+	s.cancel()
+	err := s.PipeReader.Close()
+	<-s.done
+	return err
+}
@@ -0,0 +1,53 @@
+// Package subprocess has the helpers for the output of yt-dlp, ffmpeg and ffprobe.
+package subprocess
+
+import (
+	"bytes"
+
+	"golang.org/x/exp/slog"
+)
+
+// LineLogger logs the output of a subprocess line by line at the debug level.
+type LineLogger struct {
+	log *slog.Logger
+	buf []byte
+}
+
+func NewLineLogger(log *slog.Logger, process string) *LineLogger {
+	return &LineLogger{log: log.With("process", process)}
+}
+
+func (l *LineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		// progress lines of yt-dlp and ffmpeg end with carriage returns
+		i := bytes.IndexAny(l.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		l.logLine(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the incomplete last line.
+func (l *LineLogger) Flush() {
+	l.logLine(l.buf)
+	l.buf = nil
+}
+
+func (l *LineLogger) logLine(line []byte) {
+	if line = bytes.TrimSpace(line); len(line) > 0 {
+		l.log.Debug("subprocess output", "line", string(line))
+	}
+}
+
+// LastLine returns the last non-empty line of the output, it is usually the error.
+func LastLine(output []byte) []byte {
+	output = bytes.TrimSpace(output)
+	if i := bytes.LastIndexByte(output, '\n'); i >= 0 {
+		return output[i+1:]
+	}
+	return output
+}
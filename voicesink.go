@@ -0,0 +1,157 @@
+package discobot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"discobot/ogg/opus"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// VoiceSink receives the Opus frames played in a voice channel.
+type VoiceSink interface {
+	StartSpeaking() error
+	StopSpeaking() error
+	SendOpusFrame(frame []byte) error
+	Close() error
+}
+
+// VoiceConnector connects players to voice channels.
+type VoiceConnector interface {
+	Connect(guildID, channelID dg.Snowflake) (VoiceSink, error)
+}
+
+// DisgordConnector connects to Discord voice channels.
+type DisgordConnector struct {
+	Client *dg.Client
+}
+
+func (c DisgordConnector) Connect(guildID, channelID dg.Snowflake) (VoiceSink, error) {
+	return c.Client.Guild(guildID).VoiceChannel(channelID).Connect(false, true)
+}
+
+// OggFileConnector writes what would have been sent to voice channels into Ogg Opus files
+// named <guild>-<channel>-<unix time>.opus in the directory.
+type OggFileConnector struct {
+	Dir string
+}
+
+func (c OggFileConnector) Connect(guildID, channelID dg.Snowflake) (VoiceSink, error) {
+	name := fmt.Sprintf("%s-%s-%d.opus", guildID, channelID, time.Now().Unix())
+	return NewOggFileSink(filepath.Join(c.Dir, name))
+}
+
+// OggFileSink writes the frames into an Ogg Opus file.
+type OggFileSink struct {
+	file   *os.File
+	writer *opus.Writer
+}
+
+func NewOggFileSink(path string) (*OggFileSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := opus.NewWriter(file, 2, uint32(time.Now().UnixNano()))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &OggFileSink{file: file, writer: writer}, nil
+}
+
+func (s *OggFileSink) StartSpeaking() error { return nil }
+
+func (s *OggFileSink) StopSpeaking() error { return nil }
+
+func (s *OggFileSink) SendOpusFrame(frame []byte) error {
+	return s.writer.WritePacket(frame)
+}
+
+func (s *OggFileSink) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// MemorySink keeps the frames in memory, e.g. to test the pipeline without Discord.
+type MemorySink struct {
+	GuildID, ChannelID dg.Snowflake
+
+	mu       sync.Mutex
+	frames   [][]byte
+	speaking bool
+	closed   bool
+}
+
+func (s *MemorySink) StartSpeaking() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.speaking = true
+	return nil
+}
+
+func (s *MemorySink) StopSpeaking() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.speaking = false
+	return nil
+}
+
+func (s *MemorySink) SendOpusFrame(frame []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("voice sink is closed")
+	}
+	s.frames = append(s.frames, append([]byte(nil), frame...))
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Frames returns the received frames.
+func (s *MemorySink) Frames() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.frames...)
+}
+
+func (s *MemorySink) Speaking() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.speaking
+}
+
+// MemoryConnector connects to memory sinks.
+type MemoryConnector struct {
+	mu    sync.Mutex
+	sinks []*MemorySink
+}
+
+func (c *MemoryConnector) Connect(guildID, channelID dg.Snowflake) (VoiceSink, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sink := &MemorySink{GuildID: guildID, ChannelID: channelID}
+	c.sinks = append(c.sinks, sink)
+	return sink, nil
+}
+
+// Sinks returns the sinks in the order of connecting.
+func (c *MemoryConnector) Sinks() []*MemorySink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*MemorySink(nil), c.sinks...)
+}
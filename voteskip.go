@@ -0,0 +1,123 @@
+package discobot
+
+import (
+	"math"
+	"strings"
+	"sync"
+
+	dg "github.com/andersfylling/disgord"
+)
+
+// SkipVotes collects skip votes for the track that is currently playing.
+// Votes are bound to a task and are dropped as soon as another task starts.
+type SkipVotes struct {
+	mu     sync.Mutex
+	task   *Task
+	voters map[dg.Snowflake]struct{}
+}
+
+func NewSkipVotes() SkipVotes {
+	return SkipVotes{voters: make(map[dg.Snowflake]struct{})}
+}
+
+// Reset binds the votes to the given task and forgets all previous votes.
+func (sv *SkipVotes) Reset(task *Task) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	sv.task = task
+	for userID := range sv.voters {
+		delete(sv.voters, userID)
+	}
+}
+
+// Vote registers a vote of the user for skipping the task and returns
+// the number of votes for it. It returns false if the task is not playing anymore.
+func (sv *SkipVotes) Vote(task *Task, userID dg.Snowflake) (int, bool) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if task == nil || sv.task != task {
+		return 0, false
+	}
+
+	sv.voters[userID] = struct{}{}
+	return len(sv.voters), true
+}
+
+// requiredVotes returns the number of votes needed to skip a track
+// when there are the given number of listeners.
+func requiredVotes(listeners int, ratio float64) int {
+	required := int(math.Ceil(float64(listeners) * ratio))
+	if required < 1 {
+		required = 1
+	}
+	return required
+}
+
+// djRoles caches the IDs of the roles named "DJ" by guild, it is updated by the gateway events,
+// so checking the default DJ role doesn't request the roles of the guild.
+type djRoles struct {
+	mu    sync.Mutex
+	roles map[dg.Snowflake]map[dg.Snowflake]struct{}
+}
+
+// set replaces the cached roles of the guild.
+func (r *djRoles) set(guildID dg.Snowflake, roles []*dg.Role) {
+	ids := make(map[dg.Snowflake]struct{})
+	for _, role := range roles {
+		if isDJRole(role) {
+			ids[role.ID] = struct{}{}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.roles == nil {
+		r.roles = make(map[dg.Snowflake]map[dg.Snowflake]struct{})
+	}
+	r.roles[guildID] = ids
+}
+
+// update caches the created or updated role, the roles of guilds which aren't cached are ignored.
+func (r *djRoles) update(guildID dg.Snowflake, role *dg.Role) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids, ok := r.roles[guildID]
+	if !ok {
+		return
+	}
+	if isDJRole(role) {
+		ids[role.ID] = struct{}{}
+	} else {
+		delete(ids, role.ID)
+	}
+}
+
+func (r *djRoles) remove(guildID, roleID dg.Snowflake) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.roles[guildID], roleID)
+}
+
+// has reports whether any of the roles is a DJ role of the guild, false is returned if the guild isn't cached.
+func (r *djRoles) has(guildID dg.Snowflake, roleIDs []dg.Snowflake) (found, cached bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids, ok := r.roles[guildID]
+	if !ok {
+		return false, false
+	}
+	for _, roleID := range roleIDs {
+		if _, ok := ids[roleID]; ok {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+func isDJRole(role *dg.Role) bool {
+	return role != nil && strings.EqualFold(role.Name, "DJ")
+}
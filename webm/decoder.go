@@ -0,0 +1,379 @@
+// Package webm reads Opus packets from WebM (Matroska) streams, e.g. the audio-only formats of YouTube.
+package webm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"discobot/ogg/opus"
+)
+
+const opusCodecID = "A_OPUS"
+
+// defaultTimecodeScale is the duration of a timecode tick in nanoseconds if Info doesn't set it.
+const defaultTimecodeScale = 1000000
+
+// Magic is the start of WebM streams, the ID of the EBML header.
+var Magic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Packet is an Opus packet of the track.
+type Packet struct {
+	*bytes.Reader
+	// Timestamp is the position of the packet in the stream.
+	Timestamp time.Duration
+}
+
+// Decoder reads the packets of the first Opus track. Elements are read sequentially,
+// so streams without cues and with unknown sizes are supported.
+type Decoder struct {
+	r *bufio.Reader
+
+	trackNumber   uint64
+	channels      uint8
+	codecPrivate  []byte
+	codecDelay    time.Duration
+	timecodeScale uint64
+
+	clusterTimecode uint64
+	// pending are the frames of a laced block.
+	pending []*Packet
+}
+
+// NewDecoder reads the header and the tracks of the stream.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	d := &Decoder{r: bufio.NewReader(r), timecodeScale: defaultTimecodeScale}
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	if err := d.readTracks(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// CodecPrivate returns the OpusHead of the track.
+func (d *Decoder) CodecPrivate() []byte {
+	return d.codecPrivate
+}
+
+// Channels returns the number of output channels.
+func (d *Decoder) Channels() uint8 {
+	return d.channels
+}
+
+// CodecDelay returns the duration to discard from the decoder output at the start.
+func (d *Decoder) CodecDelay() time.Duration {
+	return d.codecDelay
+}
+
+// NextPacket returns the next packet of the track as *Packet, it has the shape of opus.OpusDecorder.
+func (d *Decoder) NextPacket() (io.Reader, error) {
+	packet, err := d.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// ReadPacket returns the next packet of the track, it returns io.EOF at the end of the stream.
+func (d *Decoder) ReadPacket() (*Packet, error) {
+	for len(d.pending) == 0 {
+		e, err := readElement(d.r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.id {
+		case idSegment, idCluster:
+			// the children are read in the next iterations
+		case idTimecode:
+			data, err := readData(d.r, e)
+			if err != nil {
+				return nil, err
+			}
+			d.clusterTimecode = readUint(data)
+		case idSimpleBlock:
+			data, err := readData(d.r, e)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.readBlock(data); err != nil {
+				return nil, err
+			}
+		case idBlockGroup:
+			data, err := readData(d.r, e)
+			if err != nil {
+				return nil, err
+			}
+			err = children(data, func(e element, data []byte) error {
+				if e.id != idBlock {
+					return nil
+				}
+				return d.readBlock(data)
+			})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if err := skipData(d.r, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	packet := d.pending[0]
+	d.pending = d.pending[1:]
+	return packet, nil
+}
+
+func (d *Decoder) readHeader() error {
+	e, err := readElement(d.r)
+	if err != nil {
+		return err
+	}
+	if e.id != idEBML {
+		return fmt.Errorf("invalid format: element %#x instead of the EBML header", e.id)
+	}
+	data, err := readData(d.r, e)
+	if err != nil {
+		return err
+	}
+
+	var docType string
+	err = children(data, func(e element, data []byte) error {
+		if e.id == idDocType {
+			docType = string(bytes.TrimRight(data, "\x00"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if docType != "webm" && docType != "matroska" {
+		return fmt.Errorf("unsupported document type %q", docType)
+	}
+
+	return nil
+}
+
+// readTracks reads the elements of the segment up to the tracks.
+func (d *Decoder) readTracks() error {
+	for {
+		e, err := readElement(d.r)
+		if errors.Is(err, io.EOF) {
+			return errors.New("no tracks")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch e.id {
+		case idSegment:
+			// the children are read in the next iterations
+		case idInfo:
+			data, err := readData(d.r, e)
+			if err != nil {
+				return err
+			}
+			err = children(data, func(e element, data []byte) error {
+				if e.id == idTimecodeScale {
+					d.timecodeScale = readUint(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case idTracks:
+			data, err := readData(d.r, e)
+			if err != nil {
+				return err
+			}
+			if err := children(data, d.readTrackEntry); err != nil {
+				return err
+			}
+			if d.trackNumber == 0 {
+				return errors.New("no Opus track")
+			}
+			return nil
+		case idCluster:
+			return errors.New("clusters before tracks")
+		default:
+			if err := skipData(d.r, e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *Decoder) readTrackEntry(e element, data []byte) error {
+	if e.id != idTrackEntry || d.trackNumber != 0 {
+		return nil
+	}
+
+	var number uint64
+	var codecID string
+	var codecPrivate []byte
+	var codecDelay uint64
+	var channels uint64
+	err := children(data, func(e element, data []byte) error {
+		switch e.id {
+		case idTrackNumber:
+			number = readUint(data)
+		case idCodecID:
+			codecID = string(bytes.TrimRight(data, "\x00"))
+		case idCodecPrivate:
+			codecPrivate = data
+		case idCodecDelay:
+			codecDelay = readUint(data)
+		case idAudio:
+			return children(data, func(e element, data []byte) error {
+				if e.id == idChannels {
+					channels = readUint(data)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if codecID != opusCodecID {
+		return nil
+	}
+
+	// OpusHead: magic, version, channel count, ...
+	if len(codecPrivate) >= 10 && bytes.HasPrefix(codecPrivate, []byte("OpusHead")) {
+		channels = uint64(codecPrivate[9])
+	}
+	if number == 0 || channels == 0 || channels > 255 {
+		return fmt.Errorf("invalid Opus track %d with %d channels", number, channels)
+	}
+
+	d.trackNumber = number
+	d.channels = uint8(channels)
+	d.codecPrivate = append([]byte(nil), codecPrivate...)
+	d.codecDelay = time.Duration(codecDelay)
+	return nil
+}
+
+// readBlock reads the frames of the block, blocks of other tracks are skipped.
+func (d *Decoder) readBlock(data []byte) error {
+	r := &sliceReader{data: data}
+	track, _, err := readVint(r, false)
+	if err != nil {
+		return fmt.Errorf("invalid block: %w", noEOF(err))
+	}
+	if track != d.trackNumber {
+		return nil
+	}
+	if r.Len() < 3 {
+		return errors.New("invalid block: too short")
+	}
+	timecode := int64(d.clusterTimecode) + int64(readInt16(r.next(2)))
+	flags, _ := r.ReadByte()
+
+	frames, err := unlace(r, flags)
+	if err != nil {
+		return fmt.Errorf("invalid block: %w", err)
+	}
+
+	timestamp := time.Duration(timecode * int64(d.timecodeScale))
+	for _, frame := range frames {
+		d.pending = append(d.pending, &Packet{Reader: bytes.NewReader(frame), Timestamp: timestamp})
+		// laced frames share the timecode of the block
+		if samples, err := opus.PacketSamples(frame); err == nil {
+			timestamp += time.Duration(samples) * time.Second / 48000
+		}
+	}
+
+	return nil
+}
+
+// unlace splits the data of the block into frames.
+func unlace(r *sliceReader, flags byte) ([][]byte, error) {
+	const (
+		noLacing    = 0
+		xiphLacing  = 1
+		fixedLacing = 2
+		ebmlLacing  = 3
+	)
+
+	lacing := flags >> 1 & 3
+	if lacing == noLacing {
+		return [][]byte{r.next(r.Len())}, nil
+	}
+
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	frames := int(count) + 1
+	sizes := make([]int, frames)
+
+	switch lacing {
+	case xiphLacing:
+		for i := 0; i < frames-1; i++ {
+			for {
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, noEOF(err)
+				}
+				sizes[i] += int(b)
+				if b != 0xFF {
+					break
+				}
+			}
+		}
+	case fixedLacing:
+		if r.Len()%frames != 0 {
+			return nil, errors.New("fixed lacing with uneven frames")
+		}
+		for i := range sizes {
+			sizes[i] = r.Len() / frames
+		}
+	case ebmlLacing:
+		first, _, err := readVint(r, false)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		sizes[0] = int(first)
+		for i := 1; i < frames-1; i++ {
+			raw, length, err := readVint(r, false)
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			// signed differences are stored with a bias of a half of the range
+			diff := int64(raw) - (int64(1)<<(7*length-1) - 1)
+			sizes[i] = sizes[i-1] + int(diff)
+		}
+	}
+
+	if lacing != fixedLacing {
+		rest := r.Len()
+		for _, size := range sizes[:frames-1] {
+			if size < 0 {
+				return nil, errors.New("negative frame size")
+			}
+			rest -= size
+		}
+		if rest < 0 {
+			return nil, errors.New("frames overflow the block")
+		}
+		sizes[frames-1] = rest
+	}
+
+	result := make([][]byte, frames)
+	for i, size := range sizes {
+		if size > r.Len() {
+			return nil, errors.New("frames overflow the block")
+		}
+		result[i] = r.next(size)
+	}
+	return result, nil
+}
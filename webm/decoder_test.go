@@ -0,0 +1,199 @@
+package webm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUnlace(t *testing.T) {
+	frame300 := bytes.Repeat([]byte{0xFC}, 300)
+
+	tests := []struct {
+		name  string
+		flags byte
+		data  []byte
+		want  [][]byte
+		err   bool
+	}{
+		{
+			name: "no lacing",
+			data: []byte{1, 2, 3},
+			want: [][]byte{{1, 2, 3}},
+		},
+		{
+			name:  "xiph",
+			flags: 0x02,
+			data:  []byte{2, 1, 2, 1, 2, 2, 3, 3, 3},
+			want:  [][]byte{{1}, {2, 2}, {3, 3, 3}},
+		},
+		{
+			name:  "xiph with a size over 255",
+			flags: 0x02,
+			data:  append(append([]byte{1, 0xFF, 300 - 255}, frame300...), 7),
+			want:  [][]byte{frame300, {7}},
+		},
+		{
+			name:  "fixed",
+			flags: 0x04,
+			data:  []byte{2, 1, 1, 2, 2, 3, 3},
+			want:  [][]byte{{1, 1}, {2, 2}, {3, 3}},
+		},
+		{
+			name:  "ebml",
+			flags: 0x06,
+			// the first size is 3, the second one is smaller by 1
+			data: []byte{2, 0x83, 0x80 | 62, 1, 1, 1, 2, 2, 3},
+			want: [][]byte{{1, 1, 1}, {2, 2}, {3}},
+		},
+		{
+			name:  "ebml with a two byte difference",
+			flags: 0x06,
+			// the second size is larger by 1 than the first one of 1
+			data: []byte{2, 0x81, 0x60, 0x00, 1, 2, 2, 3},
+			want: [][]byte{{1}, {2, 2}, {3}},
+		},
+		{
+			name:  "fixed with uneven frames",
+			flags: 0x04,
+			data:  []byte{1, 1, 2, 3},
+			err:   true,
+		},
+		{
+			name:  "xiph overflowing the block",
+			flags: 0x02,
+			data:  []byte{1, 5, 1, 2},
+			err:   true,
+		},
+		{
+			name:  "ebml with a negative size",
+			flags: 0x06,
+			data:  []byte{2, 0x81, 0x80, 1, 2},
+			err:   true,
+		},
+		{
+			name:  "truncated frame count",
+			flags: 0x02,
+			data:  nil,
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := unlace(&sliceReader{data: tt.data}, tt.flags)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got frames %v", frames)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(frames) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d", len(frames), len(tt.want))
+			}
+			for i := range frames {
+				if !bytes.Equal(frames[i], tt.want[i]) {
+					t.Errorf("frame %d: got %v, want %v", i, frames[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// testdata/opus.webm has the layout of the audio-only formats of YouTube: a segment and the first cluster
+// of unknown size, a second track, a BlockGroup, a negative block timecode and each kind of lacing.
+func TestDecoder(t *testing.T) {
+	data, err := os.ReadFile("testdata/opus.webm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Channels() != 2 {
+		t.Errorf("got %d channels, want 2", d.Channels())
+	}
+	if !bytes.HasPrefix(d.CodecPrivate(), []byte("OpusHead")) {
+		t.Errorf("got codec private %q, want OpusHead", d.CodecPrivate())
+	}
+	if d.CodecDelay() != 6500*time.Microsecond {
+		t.Errorf("got codec delay %s, want 6.5ms", d.CodecDelay())
+	}
+
+	var timestamps []time.Duration
+	var sizes []int
+	for {
+		packet, err := d.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamps = append(timestamps, packet.Timestamp)
+		sizes = append(sizes, packet.Len())
+	}
+
+	wantSizes := []int{6, 6, 300, 6, 6, 10, 8, 12, 4, 4}
+	if len(sizes) != len(wantSizes) {
+		t.Fatalf("got packets of sizes %v, want %v", sizes, wantSizes)
+	}
+	for i, size := range sizes {
+		if size != wantSizes[i] {
+			t.Errorf("packet %d: got %d bytes, want %d", i, size, wantSizes[i])
+		}
+		if want := time.Duration(i) * 20 * time.Millisecond; timestamps[i] != want {
+			t.Errorf("packet %d: got timestamp %s, want %s", i, timestamps[i], want)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/opus.webm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cut is in the middle of the laced block of the first cluster
+	d, err := NewDecoder(bytes.NewReader(data[:0x120]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = d.ReadPacket()
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestNewDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not EBML", data: []byte("OggS\x00\x02")},
+		{name: "unknown document type", data: []byte{0x1A, 0x45, 0xDF, 0xA3, 0x84, 0x42, 0x82, 0x81, 'x'}},
+		{name: "no tracks", data: []byte{0x1A, 0x45, 0xDF, 0xA3, 0x87, 0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}},
+		{name: "empty", data: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(bytes.NewReader(tt.data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
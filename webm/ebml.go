@@ -0,0 +1,188 @@
+package webm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// https://www.rfc-editor.org/rfc/rfc8794.html
+// https://www.matroska.org/technical/elements.html
+const (
+	idEBML    = 0x1A45DFA3
+	idDocType = 0x4282
+
+	idSegment  = 0x18538067
+	idSeekHead = 0x114D9B74
+	idInfo     = 0x1549A966
+	idTracks   = 0x1654AE6B
+	idCluster  = 0x1F43B675
+	idCues     = 0x1C53BB6B
+
+	idTimecodeScale = 0x2AD7B1
+
+	idTrackEntry   = 0xAE
+	idTrackNumber  = 0xD7
+	idTrackType    = 0x83
+	idCodecID      = 0x86
+	idCodecPrivate = 0x63A2
+	idCodecDelay   = 0x56AA
+	idAudio        = 0xE1
+	idChannels     = 0x9F
+
+	idTimecode    = 0xE7
+	idSimpleBlock = 0xA3
+	idBlockGroup  = 0xA0
+	idBlock       = 0xA1
+)
+
+// unknownSize is the size of master elements written before their length is known, e.g. live streams.
+const unknownSize = math.MaxUint64
+
+// maxElementSize limits the elements read into memory, blocks of audio are far smaller.
+const maxElementSize = 16 << 20
+
+var errTooLarge = errors.New("element is too large")
+
+type element struct {
+	id   uint32
+	size uint64
+}
+
+// readVint reads a variable size integer. The length marker is kept for IDs and cleared for sizes,
+// sizes with all bits set are unknown.
+func readVint(r io.ByteReader, keepMarker bool) (uint64, int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		if mask == 1 {
+			return 0, 0, errors.New("invalid variable size integer")
+		}
+		length++
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	allOnes := value == uint64(0xFF>>length)
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, noEOF(err)
+		}
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, length, nil
+	}
+
+	return value, length, nil
+}
+
+func readElement(r io.ByteReader) (element, error) {
+	id, _, err := readVint(r, true)
+	if err != nil {
+		return element{}, err
+	}
+	if id > math.MaxUint32 {
+		return element{}, fmt.Errorf("invalid element ID %#x", id)
+	}
+	size, _, err := readVint(r, false)
+	if err != nil {
+		return element{}, noEOF(err)
+	}
+	return element{id: uint32(id), size: size}, nil
+}
+
+// readData reads the data of the element with a known size.
+func readData(r io.Reader, e element) ([]byte, error) {
+	if e.size == unknownSize {
+		return nil, fmt.Errorf("element %#x has unknown size", e.id)
+	}
+	if e.size > maxElementSize {
+		return nil, fmt.Errorf("element %#x: %w", e.id, errTooLarge)
+	}
+	data := make([]byte, e.size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, noEOF(err)
+	}
+	return data, nil
+}
+
+func skipData(r io.Reader, e element) error {
+	if e.size == unknownSize {
+		return fmt.Errorf("element %#x has unknown size", e.id)
+	}
+	n, err := io.CopyN(io.Discard, r, int64(e.size))
+	if err == io.EOF && uint64(n) < e.size {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// children parses the data of a master element.
+func children(data []byte, fn func(e element, data []byte) error) error {
+	r := &sliceReader{data: data}
+	for r.Len() > 0 {
+		e, err := readElement(r)
+		if err != nil {
+			return err
+		}
+		if e.size == unknownSize || e.size > uint64(r.Len()) {
+			return fmt.Errorf("element %#x overflows its parent", e.id)
+		}
+		if err := fn(e, r.next(int(e.size))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readInt16(data []byte) int16 {
+	return int16(binary.BigEndian.Uint16(data))
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type sliceReader struct {
+	data []byte
+}
+
+func (r *sliceReader) Len() int {
+	return len(r.data)
+}
+
+func (r *sliceReader) ReadByte() (byte, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
+
+func (r *sliceReader) next(n int) []byte {
+	data := r.data[:n]
+	r.data = r.data[n:]
+	return data
+}
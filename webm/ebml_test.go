@@ -0,0 +1,109 @@
+package webm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadVint(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		keepMarker bool
+		want       uint64
+		length     int
+		err        error
+	}{
+		{name: "one byte", data: []byte{0x81}, want: 1, length: 1},
+		{name: "zero", data: []byte{0x80}, want: 0, length: 1},
+		{name: "two bytes", data: []byte{0x40, 0x02}, want: 2, length: 2},
+		{name: "max of one byte", data: []byte{0xBF, 0x00}, want: 0x3F, length: 1},
+		{name: "eight bytes", data: []byte{0x01, 0, 0, 0, 0, 0, 0x01, 0x00}, want: 0x100, length: 8},
+		{name: "ID keeps the marker", data: []byte{0x1A, 0x45, 0xDF, 0xA3}, keepMarker: true, want: 0x1A45DFA3, length: 4},
+		{name: "one byte ID", data: []byte{0xA3}, keepMarker: true, want: 0xA3, length: 1},
+		{name: "ID with all bits set", data: []byte{0xFF}, keepMarker: true, want: 0xFF, length: 1},
+		{name: "unknown size", data: []byte{0xFF}, want: unknownSize, length: 1},
+		{name: "unknown size of eight bytes", data: []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, want: unknownSize, length: 8},
+		{name: "not all bits set", data: []byte{0x7F, 0xFE}, want: 0x3FFE, length: 2},
+		{name: "no marker", data: []byte{0x00, 0x81}, err: errors.New("invalid variable size integer")},
+		{name: "truncated", data: []byte{0x40}, err: io.ErrUnexpectedEOF},
+		{name: "empty", data: nil, err: io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, length, err := readVint(bytes.NewReader(tt.data), tt.keepMarker)
+			if tt.err != nil {
+				if err == nil || err.Error() != tt.err.Error() {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want || length != tt.length {
+				t.Errorf("got %#x of %d bytes, want %#x of %d bytes", got, length, tt.want, tt.length)
+			}
+		})
+	}
+}
+
+func TestReadElement(t *testing.T) {
+	e, err := readElement(bytes.NewReader([]byte{0x1F, 0x43, 0xB6, 0x75, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.id != idCluster || e.size != unknownSize {
+		t.Errorf("got element %#x of size %d, want the cluster of unknown size", e.id, e.size)
+	}
+
+	if _, err := readElement(bytes.NewReader([]byte{0xA3})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v for the element without the size, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := readElement(bytes.NewReader([]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x81})); err == nil {
+		t.Error("expected an error for the ID longer than 4 bytes")
+	}
+}
+
+func TestChildren(t *testing.T) {
+	// TrackNumber 1 and CodecID "A_OPUS"
+	data := []byte{0xD7, 0x81, 0x01, 0x86, 0x86, 'A', '_', 'O', 'P', 'U', 'S'}
+
+	var ids []uint32
+	err := children(data, func(e element, data []byte) error {
+		ids = append(ids, e.id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != idTrackNumber || ids[1] != idCodecID {
+		t.Errorf("got children %#x", ids)
+	}
+
+	if err := children(data[:len(data)-1], func(element, []byte) error { return nil }); err == nil {
+		t.Error("expected an error for the child overflowing its parent")
+	}
+	unknown := []byte{0xE1, 0xFF}
+	if err := children(unknown, func(element, []byte) error { return nil }); err == nil {
+		t.Error("expected an error for the child of unknown size")
+	}
+}
+
+func TestReadData(t *testing.T) {
+	if _, err := readData(bytes.NewReader(nil), element{id: idCluster, size: unknownSize}); err == nil {
+		t.Error("expected an error for the element of unknown size")
+	}
+	if _, err := readData(bytes.NewReader(nil), element{id: idSimpleBlock, size: maxElementSize + 1}); !errors.Is(err, errTooLarge) {
+		t.Errorf("got error %v, want %v", err, errTooLarge)
+	}
+	if _, err := readData(bytes.NewReader([]byte{1, 2}), element{id: idSimpleBlock, size: 3}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if err := skipData(bytes.NewReader([]byte{1, 2}), element{id: idCues, size: 3}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,300 @@
+package ytdlp
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"discobot/metrics"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// DefaultMetadataCacheTTL is how long fetched metadata is cached if the TTL isn't set.
+	DefaultMetadataCacheTTL = 6 * time.Hour
+
+	// streamExpiryMargin is the time left to download a track before its stream URLs expire.
+	streamExpiryMargin = 30 * time.Minute
+)
+
+type MetadataCacheConfig struct {
+	// Size is the max number of cached results, the cache is disabled if it is 0.
+	Size int
+	// TTL limits how long a result is cached, results with stream URLs expire before the URLs do.
+	TTL time.Duration
+	// Dir keeps the cache between restarts, the cache is kept in memory only if it is empty.
+	Dir string
+}
+
+// metadataCache keeps the results of Fetch by the canonical URL, the least recently used ones are evicted.
+type metadataCache struct {
+	cfg MetadataCacheConfig
+	log *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru has the most recently used entries at the front.
+	lru *list.List
+}
+
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Expires time.Time       `json:"expires"`
+	Info    json.RawMessage `json:"info"`
+}
+
+func newMetadataCache(cfg MetadataCacheConfig, log *slog.Logger) *metadataCache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultMetadataCacheTTL
+	}
+	c := &metadataCache{
+		cfg:     cfg,
+		log:     log,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if cfg.Dir != "" {
+		if err := c.load(); err != nil {
+			log.Warn("failed to load the metadata cache", "dir", cfg.Dir, "err", err)
+		}
+	}
+	return c
+}
+
+// get returns the cached result of the URL if it hasn't expired.
+func (c *metadataCache) get(rawURL string) (*FetchResult, bool) {
+	key := canonicalURL(rawURL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		metrics.MetadataCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.Expires) {
+		c.remove(elem)
+		metrics.MetadataCacheRequests.WithLabelValues("expired").Inc()
+		return nil, false
+	}
+
+	fr := &FetchResult{}
+	if err := fr.UnmarshalJSON(entry.Info); err != nil {
+		c.remove(elem)
+		metrics.MetadataCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	metrics.MetadataCacheRequests.WithLabelValues("hit").Inc()
+	return fr, true
+}
+
+// put caches the result by the requested URL and by the canonical URL of the video.
+func (c *metadataCache) put(rawURL string, fr *FetchResult) {
+	expires := time.Now().Add(c.cfg.TTL)
+	if !fr.Expires.IsZero() && fr.Expires.Add(-streamExpiryMargin).Before(expires) {
+		expires = fr.Expires.Add(-streamExpiryMargin)
+	}
+	if !time.Now().Before(expires) {
+		return
+	}
+
+	keys := []string{canonicalURL(rawURL)}
+	if key := canonicalURL(fr.URL); key != keys[0] {
+		keys = append(keys, key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		entry := &cacheEntry{Key: key, Expires: expires, Info: fr.rawInfo}
+		if elem, ok := c.entries[key]; ok {
+			elem.Value = entry
+			c.lru.MoveToFront(elem)
+		} else {
+			c.entries[key] = c.lru.PushFront(entry)
+		}
+		c.save(entry)
+	}
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove deletes the entry from memory and disk, the lock must be held.
+func (c *metadataCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.Key)
+	if c.cfg.Dir == "" {
+		return
+	}
+	if err := os.Remove(c.path(entry.Key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.log.Warn("failed to remove the cached metadata", "err", err)
+	}
+}
+
+func (c *metadataCache) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.cfg.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// save writes the entry to disk if the cache directory is set.
+func (c *metadataCache) save(entry *cacheEntry) {
+	if c.cfg.Dir == "" {
+		return
+	}
+	if err := c.write(entry); err != nil {
+		c.log.Warn("failed to save the cached metadata", "err", err)
+	}
+}
+
+func (c *metadataCache) write(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.cfg.Dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.cfg.Dir, "metadata.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path(entry.Key))
+}
+
+// load reads the entries of the cache directory, the recently written ones are kept.
+func (c *metadataCache) load() error {
+	files, err := os.ReadDir(c.cfg.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var entries []loaded
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(c.cfg.Dir, file.Name())
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || !time.Now().Before(entry.Expires) || path != c.path(entry.Key) {
+			_ = os.Remove(path)
+			continue
+		}
+		entries = append(entries, loaded{entry: &entry, modTime: info.ModTime()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		c.entries[e.entry.Key] = c.lru.PushFront(e.entry)
+	}
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+	}
+	c.log.Info("loaded the metadata cache", "entries", c.lru.Len())
+	return nil
+}
+
+// canonicalURL returns the key of the URL: the video ID for YouTube, otherwise the URL
+// without the scheme, the www prefix, the fragment and with sorted query parameters.
+func canonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	query := u.Query()
+	switch host {
+	case "youtu.be":
+		if id := strings.Trim(u.Path, "/"); id != "" && !query.Has("list") {
+			return "youtube:" + id
+		}
+	case "youtube.com", "m.youtube.com", "music.youtube.com":
+		// URLs with a playlist fetch the whole playlist
+		if query.Has("list") {
+			break
+		}
+		if u.Path == "/watch" && query.Get("v") != "" {
+			return "youtube:" + query.Get("v")
+		}
+		for _, prefix := range []string{"/shorts/", "/live/", "/embed/"} {
+			if id, ok := strings.CutPrefix(u.Path, prefix); ok && id != "" {
+				return "youtube:" + strings.Trim(id, "/")
+			}
+		}
+	}
+
+	key := host + u.EscapedPath()
+	if len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+// streamExpiry returns the earliest expiration of the stream URLs, e.g. the expire parameter of YouTube.
+func streamExpiry(urls []string) time.Time {
+	var earliest time.Time
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		expire := u.Query().Get("expire")
+		if expire == "" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(expire, 10, 64)
+		if err != nil || seconds <= 0 {
+			continue
+		}
+		if t := time.Unix(seconds, 0); earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,193 @@
+package ytdlp
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// testFetchResult returns the result of the video with the stream URL expiring at expire, zero doesn't expire.
+func testFetchResult(t *testing.T, id string, expire time.Time) *FetchResult {
+	t.Helper()
+
+	streamURL := "https://rr1.googlevideo.com/videoplayback?itag=251"
+	if !expire.IsZero() {
+		streamURL += fmt.Sprintf("&expire=%d", expire.Unix())
+	}
+	info := fmt.Sprintf(`{"id":%q,"extractor_key":"Youtube","title":"Video %s","webpage_url":"https://www.youtube.com/watch?v=%s","url":%q}`,
+		id, id, id, streamURL)
+
+	fr := &FetchResult{}
+	if err := fr.UnmarshalJSON([]byte(info)); err != nil {
+		t.Fatal(err)
+	}
+	return fr
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "  https://youtube.com/watch?v=dQw4w9WgXcQ&t=42  ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "http://m.youtube.com/watch?feature=share&v=dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://music.youtube.com/watch?v=dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://youtu.be/dQw4w9WgXcQ?si=abc", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://www.youtube.com/shorts/dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://www.youtube.com/live/dQw4w9WgXcQ/", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://www.youtube.com/embed/dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{url: "https://WWW.YouTube.com/watch?v=dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		// playlists aren't keyed by the video
+		{url: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL1", want: "youtube.com/watch?list=PL1&v=dQw4w9WgXcQ"},
+		{url: "https://youtu.be/dQw4w9WgXcQ?list=PL1", want: "youtu.be/dQw4w9WgXcQ?list=PL1"},
+		{url: "https://www.youtube.com/watch", want: "youtube.com/watch"},
+		{url: "https://soundcloud.com/artist/track#t=1", want: "soundcloud.com/artist/track"},
+		{url: "http://www.example.com/a?b=2&a=1", want: "example.com/a?a=1&b=2"},
+		{url: "https://example.com/a%20b", want: "example.com/a%20b"},
+		{url: "not a url", want: "not a url"},
+		{url: "ytsearch:never gonna give you up", want: "ytsearch:never gonna give you up"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := canonicalURL(tt.url); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		urls []string
+		want int64
+	}{
+		{name: "no URLs", want: 0},
+		{name: "no expire", urls: []string{"https://example.com/audio.webm"}, want: 0},
+		{name: "expire", urls: []string{"https://rr1.googlevideo.com/videoplayback?expire=1700000000&itag=251"}, want: 1700000000},
+		{
+			name: "earliest of formats",
+			urls: []string{
+				"https://rr1.googlevideo.com/videoplayback?expire=1700000500",
+				"",
+				"https://rr1.googlevideo.com/videoplayback?expire=1700000000",
+				"https://example.com/audio.webm",
+			},
+			want: 1700000000,
+		},
+		{name: "invalid expire", urls: []string{"https://example.com/?expire=soon", "https://example.com/?expire=-5"}, want: 0},
+		{name: "invalid URL", urls: []string{"https://example.com/%zz?expire=1700000000"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := streamExpiry(tt.urls)
+			if tt.want == 0 {
+				if !got.IsZero() {
+					t.Errorf("got %s, want no expiry", got)
+				}
+				return
+			}
+			if got.Unix() != tt.want {
+				t.Errorf("got %d, want %d", got.Unix(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMetadataCache(MetadataCacheConfig{Size: 2, TTL: time.Hour}, testLogger)
+
+	for _, id := range []string{"a", "b"} {
+		fr := testFetchResult(t, id, time.Time{})
+		c.put(fr.URL, fr)
+	}
+	// a is used, so b is evicted by c
+	if _, ok := c.get("https://youtu.be/a"); !ok {
+		t.Fatal("a isn't cached")
+	}
+	fr := testFetchResult(t, "c", time.Time{})
+	c.put(fr.URL, fr)
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		got, ok := c.get("https://www.youtube.com/watch?v=" + id)
+		if ok != want {
+			t.Errorf("%s: got cached %v, want %v", id, ok, want)
+			continue
+		}
+		if ok && got.Title != "Video "+id {
+			t.Errorf("%s: got title %q", id, got.Title)
+		}
+	}
+}
+
+func TestMetadataCacheKeysByRequestedAndVideoURL(t *testing.T) {
+	c := newMetadataCache(MetadataCacheConfig{Size: 10, TTL: time.Hour}, testLogger)
+
+	fr := testFetchResult(t, "a", time.Time{})
+	c.put("https://example.com/redirect?to=a", fr)
+
+	for _, url := range []string{"https://example.com/redirect?to=a", "https://youtu.be/a"} {
+		if _, ok := c.get(url); !ok {
+			t.Errorf("%s isn't cached", url)
+		}
+	}
+}
+
+func TestMetadataCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		expire time.Time
+		wait   time.Duration
+		cached bool
+	}{
+		{name: "before the TTL", ttl: time.Hour, cached: true},
+		{name: "after the TTL", ttl: 20 * time.Millisecond, wait: 40 * time.Millisecond},
+		{name: "stream URLs expire after the TTL", ttl: time.Hour, expire: time.Now().Add(2 * time.Hour), cached: true},
+		// the result is dropped before the URLs expire, so the download doesn't fail
+		{name: "stream URLs expire within the margin", ttl: time.Hour, expire: time.Now().Add(streamExpiryMargin - time.Minute)},
+		{name: "stream URLs expired", ttl: time.Hour, expire: time.Now().Add(-time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newMetadataCache(MetadataCacheConfig{Size: 10, TTL: tt.ttl}, testLogger)
+			fr := testFetchResult(t, "a", tt.expire)
+			c.put(fr.URL, fr)
+			time.Sleep(tt.wait)
+
+			if _, ok := c.get(fr.URL); ok != tt.cached {
+				t.Errorf("got cached %v, want %v", ok, tt.cached)
+			}
+			if !tt.cached && c.lru.Len() != 0 {
+				t.Errorf("got %d entries after expiry, want 0", c.lru.Len())
+			}
+		})
+	}
+}
+
+func TestMetadataCacheLoadsTheDirectory(t *testing.T) {
+	dir := t.TempDir()
+	c := newMetadataCache(MetadataCacheConfig{Size: 2, TTL: time.Hour, Dir: dir}, testLogger)
+	for _, id := range []string{"a", "b", "c"} {
+		fr := testFetchResult(t, id, time.Time{})
+		c.put(fr.URL, fr)
+		// the order of loading is by the modification time
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	loaded := newMetadataCache(MetadataCacheConfig{Size: 2, TTL: time.Hour, Dir: dir}, testLogger)
+	for id, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		if _, ok := loaded.get("https://youtu.be/" + id); ok != want {
+			t.Errorf("%s: got cached %v, want %v", id, ok, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package ytdlp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnsupportedURL = errors.New("unsupported URL")
+	ErrGeoBlocked     = errors.New("video is not available in this country")
+	ErrPrivateVideo   = errors.New("private video")
+	ErrAgeRestricted  = errors.New("age-restricted video")
+	ErrUnavailable    = errors.New("video is unavailable")
+	ErrTimeout        = errors.New("timeout")
+	// ErrNetwork is a failure which may succeed on retry, e.g. a dropped connection or an overloaded server.
+	ErrNetwork = errors.New("network error")
+	// ErrForbidden is returned for expired stream URLs.
+	ErrForbidden = errors.New("access to the stream is forbidden")
+)
+
+// classifiers map yt-dlp error messages to the errors, the first match wins.
+var classifiers = []struct {
+	substrings []string
+	err        error
+}{
+	{[]string{"unsupported url", "is not a valid url"}, ErrUnsupportedURL},
+	{[]string{"not available in your country", "geo restriction", "geo-restricted"}, ErrGeoBlocked},
+	{[]string{"private video", "video is private"}, ErrPrivateVideo},
+	{[]string{"confirm your age", "age-restricted", "age restricted", "inappropriate for some users"}, ErrAgeRestricted},
+	{[]string{"video unavailable", "video is unavailable", "has been removed"}, ErrUnavailable},
+	{[]string{"http error 403"}, ErrForbidden},
+	{[]string{
+		"timed out", "connection reset", "connection refused", "connection aborted", "remote end closed",
+		"temporary failure in name resolution", "name or service not known", "network is unreachable",
+		"incompleteread", "incomplete read", "http error 429", "http error 5",
+	}, ErrNetwork},
+}
+
+// IsTransient reports whether the failure may succeed on retry.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrTimeout)
+}
+
+// failureCategory returns the metrics label of the error.
+func failureCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsupportedURL):
+		return "unsupported_url"
+	case errors.Is(err, ErrGeoBlocked):
+		return "geo_blocked"
+	case errors.Is(err, ErrPrivateVideo):
+		return "private_video"
+	case errors.Is(err, ErrAgeRestricted):
+		return "age_restricted"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrNetwork):
+		return "network"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	default:
+		return "other"
+	}
+}
+
+// Error is a failure of yt-dlp.
+type Error struct {
+	// Kind is one of the errors of the package, it is nil if the failure is not classified.
+	Kind error
+	// Message is the last error message of yt-dlp.
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := e.Err.Error()
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Kind != nil {
+		msg = e.Kind.Error() + ": " + msg
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newError classifies the failure of yt-dlp by its stderr.
+func newError(err error, stderr []byte) *Error {
+	message := lastErrorLine(stderr)
+	lower := strings.ToLower(message)
+
+	var kind error
+	for _, c := range classifiers {
+		for _, substring := range c.substrings {
+			if strings.Contains(lower, substring) {
+				kind = c.err
+				break
+			}
+		}
+		if kind != nil {
+			break
+		}
+	}
+
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func lastErrorLine(stderr []byte) string {
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "ERROR:") {
+			last = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+		}
+	}
+	return last
+}
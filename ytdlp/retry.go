@@ -0,0 +1,165 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"discobot/metrics"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	DefaultRetries      = 2
+	DefaultRetryBackoff = time.Second
+
+	// maxRetryBackoff limits the doubled delay between retries.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// errStalled is the cause of canceling the download without new audio.
+var errStalled = errors.New("no audio is written")
+
+// permanentError stops the retries regardless of the failure.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// retry runs fn until it succeeds, fails with an error that isn't transient or the retries run out.
+// The delay between attempts starts with the backoff and is doubled after each attempt.
+func (c *Client) retry(ctx context.Context, log *slog.Logger, op string, fn func() error) error {
+	backoff := c.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if err == nil || ctx.Err() != nil || attempt >= c.cfg.Retries || !IsTransient(err) {
+			return err
+		}
+
+		log.Warn("retrying after the failure", "op", op, "attempt", attempt+1, "backoff", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles the delay between retries up to maxRetryBackoff.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// progressWriter counts the written audio and reports the progress to the watchdog.
+// The time to the first audio is observed by the download latency metric.
+type progressWriter struct {
+	w        io.WriteCloser
+	progress chan struct{}
+	start    time.Time
+
+	mu      sync.Mutex
+	written int64
+	// writing is set while a write is blocked by the consumer, e.g. a paused player
+	writing bool
+}
+
+func newProgressWriter(w io.WriteCloser) *progressWriter {
+	return &progressWriter{w: w, progress: make(chan struct{}, 1), start: time.Now()}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.setWriting(true)
+	n, err := pw.w.Write(p)
+	pw.setWriting(false)
+	if n > 0 {
+		pw.mu.Lock()
+		if pw.written == 0 {
+			metrics.DownloadLatency.Observe(time.Since(pw.start).Seconds())
+		}
+		pw.written += int64(n)
+		pw.mu.Unlock()
+		select {
+		case pw.progress <- struct{}{}:
+		default:
+		}
+	}
+	return n, err
+}
+
+// Close closes the output if the audio is written, so an attempt canceled before that can be retried.
+func (pw *progressWriter) Close() error {
+	if pw.Written() == 0 {
+		return nil
+	}
+	return pw.w.Close()
+}
+
+func (pw *progressWriter) Written() int64 {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.written
+}
+
+func (pw *progressWriter) setWriting(writing bool) {
+	pw.mu.Lock()
+	pw.writing = writing
+	pw.mu.Unlock()
+}
+
+// Writing reports whether the output is being written, the download doesn't stall then.
+func (pw *progressWriter) Writing() bool {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.writing
+}
+
+// watch cancels the download with errStalled if the first audio isn't written for the start timeout
+// or the next audio isn't written for the stall timeout, zero timeouts aren't limited.
+// The time a write waits for the consumer, e.g. while the player is paused, isn't a stall.
+func watch(ctx context.Context, cancel context.CancelCauseFunc, w *progressWriter, startTimeout, stallTimeout time.Duration) {
+	timeout := startTimeout
+	for {
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if timeout > 0 {
+			timer = time.NewTimer(timeout)
+			expired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-w.progress:
+			if timer != nil {
+				timer.Stop()
+			}
+			timeout = stallTimeout
+		case <-expired:
+			if w.Writing() {
+				timeout = stallTimeout
+				continue
+			}
+			cancel(errStalled)
+			return
+		}
+	}
+}
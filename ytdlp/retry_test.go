@@ -0,0 +1,373 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{backoff: time.Second, want: 2 * time.Second},
+		{backoff: 10 * time.Second, want: 20 * time.Second},
+		{backoff: maxRetryBackoff / 2, want: maxRetryBackoff},
+		{backoff: 20 * time.Second, want: maxRetryBackoff},
+		{backoff: maxRetryBackoff, want: maxRetryBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := nextBackoff(tt.backoff); got != tt.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", tt.backoff, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := &Error{Kind: ErrNetwork, Err: errors.New("exit status 1")}
+	unavailable := &Error{Kind: ErrUnavailable, Err: errors.New("exit status 1")}
+
+	tests := []struct {
+		name     string
+		retries  int
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "success", retries: 2, errs: []error{nil}, attempts: 1},
+		{name: "transient failure", retries: 2, errs: []error{transient, nil}, attempts: 2},
+		{name: "retries run out", retries: 2, errs: []error{transient, transient, transient, nil}, attempts: 3, err: ErrNetwork},
+		{name: "no retries", retries: 0, errs: []error{transient, nil}, attempts: 1, err: ErrNetwork},
+		{name: "not transient", retries: 2, errs: []error{unavailable, nil}, attempts: 1, err: ErrUnavailable},
+		// e.g. the audio is already written
+		{name: "permanent", retries: 2, errs: []error{&permanentError{err: transient}, nil}, attempts: 1, err: ErrNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(Config{Retries: tt.retries, RetryBackoff: time.Millisecond, Logger: testLogger})
+
+			attempts := 0
+			err := c.retry(context.Background(), testLogger, "test", func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if attempts != tt.attempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.attempts)
+			}
+			if tt.err == nil && err != nil || !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				t.Error("the permanent error isn't unwrapped")
+			}
+		})
+	}
+}
+
+func TestRetryDoublesTheBackoff(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	c := New(Config{Retries: 3, RetryBackoff: backoff, Logger: testLogger})
+
+	var attempts []time.Time
+	_ = c.retry(context.Background(), testLogger, "test", func() error {
+		attempts = append(attempts, time.Now())
+		return &Error{Kind: ErrTimeout, Err: errStalled}
+	})
+
+	if len(attempts) != 4 {
+		t.Fatalf("got %d attempts, want 4", len(attempts))
+	}
+	for i := 1; i < len(attempts); i++ {
+		want := backoff << (i - 1)
+		if got := attempts[i].Sub(attempts[i-1]); got < want {
+			t.Errorf("got %s before attempt %d, want at least %s", got, i+1, want)
+		}
+	}
+}
+
+func TestRetryCanceledDuringBackoff(t *testing.T) {
+	c := New(Config{Retries: 2, RetryBackoff: time.Hour, Logger: testLogger})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	attempts := 0
+	err := c.retry(ctx, testLogger, "test", func() error {
+		attempts++
+		return &Error{Kind: ErrNetwork, Err: errors.New("exit status 1")}
+	})
+	if attempts != 1 || !errors.Is(err, ErrNetwork) {
+		t.Errorf("got %d attempts and error %v, want the error of the first attempt", attempts, err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		stderr    string
+		kind      error
+		transient bool
+	}{
+		{stderr: "ERROR: [youtube] abc: Unable to download API page: <urlopen error [Errno 104] Connection reset by peer>", kind: ErrNetwork, transient: true},
+		{stderr: "ERROR: unable to download video data: HTTP Error 503: Service Unavailable", kind: ErrNetwork, transient: true},
+		{stderr: "ERROR: unable to download video data: HTTP Error 429: Too Many Requests", kind: ErrNetwork, transient: true},
+		{stderr: "ERROR: [youtube] abc: Read timed out.", kind: ErrNetwork, transient: true},
+		{stderr: "ERROR: [Errno -3] Temporary failure in name resolution", kind: ErrNetwork, transient: true},
+		{stderr: "ERROR: unable to download video data: HTTP Error 403: Forbidden", kind: ErrForbidden},
+		{stderr: "ERROR: [youtube] abc: Video unavailable", kind: ErrUnavailable},
+		{stderr: "ERROR: [youtube] abc: Private video. Sign in if you've been granted access", kind: ErrPrivateVideo},
+		{stderr: "ERROR: [youtube] abc: Sign in to confirm your age", kind: ErrAgeRestricted},
+		{stderr: "ERROR: Unsupported URL: https://example.com", kind: ErrUnsupportedURL},
+		// the last error line is classified
+		{stderr: "ERROR: Connection reset by peer\nERROR: [youtube] abc: Video unavailable", kind: ErrUnavailable},
+		{stderr: "WARNING: Connection reset by peer\n", kind: nil},
+		{stderr: "", kind: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stderr, func(t *testing.T) {
+			err := newError(errors.New("exit status 1"), []byte(tt.stderr))
+			if err.Kind != tt.kind {
+				t.Errorf("got kind %v, want %v", err.Kind, tt.kind)
+			}
+			if IsTransient(err) != tt.transient {
+				t.Errorf("got transient %v, want %v", IsTransient(err), tt.transient)
+			}
+		})
+	}
+
+	if !IsTransient(fmt.Errorf("download: %w", &Error{Kind: ErrTimeout, Err: errStalled})) {
+		t.Error("the wrapped timeout isn't transient")
+	}
+}
+
+// blockingWriter blocks the writes until it is released, e.g. like the pipe to a paused player.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// watched runs the watchdog until it cancels or the test ends.
+func watched(t *testing.T, w *progressWriter, startTimeout, stallTimeout time.Duration) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	t.Cleanup(func() { cancel(nil) })
+	go watch(ctx, cancel, w, startTimeout, stallTimeout)
+	return ctx
+}
+
+func stalled(ctx context.Context, within time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return errors.Is(context.Cause(ctx), errStalled)
+	case <-time.After(within):
+		return false
+	}
+}
+
+func TestWatchTimeouts(t *testing.T) {
+	t.Run("start timeout", func(t *testing.T) {
+		w := newBlockingWriter()
+		close(w.release)
+		ctx := watched(t, newProgressWriter(w), 20*time.Millisecond, time.Hour)
+		if !stalled(ctx, time.Second) {
+			t.Error("the download isn't canceled without audio")
+		}
+	})
+
+	t.Run("stall timeout", func(t *testing.T) {
+		w := newBlockingWriter()
+		close(w.release)
+		pw := newProgressWriter(w)
+		ctx := watched(t, pw, 50*time.Millisecond, 50*time.Millisecond)
+
+		// the progress resets the timeout
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := pw.Write([]byte("audio")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ctx.Err() != nil {
+			t.Fatal("the download is canceled while the audio is written")
+		}
+		if !stalled(ctx, time.Second) {
+			t.Error("the download isn't canceled after the audio stopped")
+		}
+	})
+
+	t.Run("no limits", func(t *testing.T) {
+		ctx := watched(t, newProgressWriter(newBlockingWriter()), 0, 0)
+		if stalled(ctx, 50*time.Millisecond) {
+			t.Error("the download is canceled without timeouts")
+		}
+	})
+}
+
+func TestWatchIgnoresBlockedWrites(t *testing.T) {
+	w := newBlockingWriter()
+	pw := newProgressWriter(w)
+	ctx := watched(t, pw, 20*time.Millisecond, 20*time.Millisecond)
+
+	// the player is paused before the first audio
+	written := make(chan error, 1)
+	go func() {
+		_, err := pw.Write([]byte("audio"))
+		written <- err
+	}()
+	if stalled(ctx, 100*time.Millisecond) {
+		t.Fatal("the download is canceled while the write is blocked by the consumer")
+	}
+	if !pw.Writing() {
+		t.Fatal("the blocked write isn't reported")
+	}
+
+	close(w.release)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if pw.Writing() || pw.Written() != 5 {
+		t.Fatalf("got writing %v and %d written bytes after the write", pw.Writing(), pw.Written())
+	}
+	if !stalled(ctx, time.Second) {
+		t.Error("the download isn't canceled after the consumer reads everything and the audio stops")
+	}
+}
+
+// fakeYtDlp writes a yt-dlp script running the steps of the attempts in order, the last one is repeated.
+// It returns the client and the file counting the attempts.
+func fakeYtDlp(t *testing.T, cfg Config, attempts ...string) (*Client, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "attempts")
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh\necho x >> %s\nn=$(wc -l < %s)\n", counter, counter)
+	for i, attempt := range attempts {
+		if i == len(attempts)-1 {
+			script.WriteString(attempt + "\n")
+			break
+		}
+		fmt.Fprintf(&script, "if [ $n -eq %d ]; then\n%s\nfi\n", i+1, attempt)
+	}
+	path := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(path, []byte(script.String()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.YtDlpPath = path
+	cfg.Logger = testLogger
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	return New(cfg), counter
+}
+
+func countAttempts(t *testing.T, counter string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+// webmOpusResult is downloaded by yt-dlp directly to the output.
+func webmOpusResult(t *testing.T) *FetchResult {
+	t.Helper()
+
+	fr := &FetchResult{}
+	info := `{"id":"a","extractor_key":"Youtube","webpage_url":"https://www.youtube.com/watch?v=a","duration":60,
+		"formats":[{"acodec":"opus","vcodec":"none","ext":"webm","url":"https://rr1.googlevideo.com/videoplayback"}]}`
+	if err := fr.UnmarshalJSON([]byte(info)); err != nil {
+		t.Fatal(err)
+	}
+	return fr
+}
+
+func TestDownloadRetries(t *testing.T) {
+	const (
+		reset   = `echo "ERROR: unable to download video data: <urlopen error [Errno 104] Connection reset by peer>" >&2; exit 1`
+		success = `printf audio; exit 0`
+		partial = `printf aud; ` + reset
+		hang    = `exec sleep 10`
+	)
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		attempts []string
+		want     int
+		output   string
+		err      error
+	}{
+		{name: "retried before the audio", cfg: Config{Retries: 2}, attempts: []string{reset, success}, want: 2, output: "audio"},
+		{name: "not retried after the audio", cfg: Config{Retries: 2}, attempts: []string{partial, success}, want: 1, output: "aud", err: ErrNetwork},
+		{
+			name:     "start timeout",
+			cfg:      Config{Retries: 1, DownloadStartTimeout: 50 * time.Millisecond},
+			attempts: []string{hang},
+			want:     2,
+			err:      ErrTimeout,
+		},
+		{
+			name:     "stall timeout",
+			cfg:      Config{Retries: 2, StallTimeout: 50 * time.Millisecond},
+			attempts: []string{`printf aud; exec sleep 10`},
+			want:     1,
+			output:   "aud",
+			err:      ErrTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, counter := fakeYtDlp(t, tt.cfg, tt.attempts...)
+			w := newBlockingWriter()
+			close(w.release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := c.Download(ctx, webmOpusResult(t), w, DownloadOptions{})
+			if tt.err == nil && err != nil || !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got := countAttempts(t, counter); got != tt.want {
+				t.Errorf("got %d attempts, want %d", got, tt.want)
+			}
+			if w.String() != tt.output {
+				t.Errorf("got output %q, want %q", w.String(), tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type Versions struct {
+	YtDlp  string
+	Ffmpeg string
+}
+
+// Versions runs yt-dlp and ffmpeg to check that they work and returns their versions.
+func (c *Client) Versions(ctx context.Context) (Versions, error) {
+	var versions Versions
+	var errs []error
+
+	out, err := exec.CommandContext(ctx, c.cfg.YtDlpPath, "--version").Output()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("yt-dlp: %w", err))
+	} else if versions.YtDlp = strings.TrimSpace(string(out)); versions.YtDlp == "" {
+		errs = append(errs, errors.New("yt-dlp: empty version output"))
+	}
+
+	out, err = exec.CommandContext(ctx, c.cfg.FfmpegPath, "-version").Output()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("ffmpeg: %w", err))
+	} else {
+		// ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers
+		line, _, _ := bytes.Cut(out, []byte("\n"))
+		fields := strings.Fields(string(line))
+		if len(fields) < 3 || fields[0] != "ffmpeg" || fields[1] != "version" {
+			errs = append(errs, fmt.Errorf("ffmpeg: unexpected version output %q", line))
+		} else {
+			versions.Ffmpeg = fields[2]
+		}
+	}
+
+	return versions, errors.Join(errs...)
+}
@@ -3,99 +3,406 @@ package ytdlp
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
+
+	"discobot/metrics"
+	"discobot/subprocess"
+
+	"golang.org/x/exp/slog"
 )
 
 const (
-	ffmpegPath = "ffmpeg"
-	ytDlpPath  = "yt-dlp"
+	DefaultFfmpegPath = "ffmpeg"
+	DefaultYtDlpPath  = "yt-dlp"
+
+	// killDelay is the time given to yt-dlp and ffmpeg to exit after the interrupt,
+	// after that they are killed.
+	killDelay = 3 * time.Second
 )
 
+type Config struct {
+	YtDlpPath  string
+	FfmpegPath string
+	// CacheDir is the cache directory of yt-dlp, the cache is disabled if it is empty.
+	CacheDir string
+	// FetchTimeout limits the time of fetching the metadata, zero means no limit.
+	FetchTimeout time.Duration
+	// DownloadStartTimeout limits the time until the first audio is written, zero means no limit.
+	DownloadStartTimeout time.Duration
+	// StallTimeout limits the time without new audio in the middle of the download, zero means no limit.
+	StallTimeout time.Duration
+	// Retries is the number of retries of network failures and timeouts of Fetch and of Download
+	// before the audio is written, the delay between them starts with RetryBackoff and is doubled.
+	Retries      int
+	RetryBackoff time.Duration
+	// MetadataCache caches the results of Fetch, so tracks are queued again without yt-dlp.
+	MetadataCache MetadataCacheConfig
+	// Logger logs the stderr of yt-dlp and ffmpeg at the debug level, defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Client runs yt-dlp and ffmpeg.
+type Client struct {
+	cfg Config
+	// cache is nil if the metadata cache is disabled.
+	cache *metadataCache
+}
+
+func New(cfg Config) *Client {
+	if cfg.YtDlpPath == "" {
+		cfg.YtDlpPath = DefaultYtDlpPath
+	}
+	if cfg.FfmpegPath == "" {
+		cfg.FfmpegPath = DefaultFfmpegPath
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	c := &Client{cfg: cfg}
+	if cfg.MetadataCache.Size > 0 {
+		c.cache = newMetadataCache(cfg.MetadataCache, cfg.Logger.With("component", "metadata_cache"))
+	}
+	return c
+}
+
+func (c *Client) cacheArgs() []string {
+	if c.cfg.CacheDir == "" {
+		return []string{"--no-cache-dir"}
+	}
+	return []string{"--cache-dir", c.cfg.CacheDir}
+}
+
 type FetchResult struct {
 	rawInfo []byte
+	key     string
+
+	Title string
+	URL   string
+	// Duration is the length of the video, it is 0 for live streams.
+	Duration time.Duration
+	// OpusAudio reports whether the video has an audio-only Opus format, e.g. WebM on YouTube.
+	// Its packets are copied without re-encoding if the volume isn't changed.
+	OpusAudio bool
+	// webmOpus reports whether the Opus audio is in WebM, the player reads it without ffmpeg.
+	webmOpus bool
+	// Expires is the time the stream URLs of the info expire, it is zero if they don't.
+	Expires time.Time
+}
+
+type videoInfo struct {
+	ID           string   `json:"id"`
+	ExtractorKey string   `json:"extractor_key"`
+	Title        string   `json:"title"`
+	WebpageURL   string   `json:"webpage_url"`
+	Duration     float64  `json:"duration"`
+	URL          string   `json:"url"`
+	Formats      []format `json:"formats"`
+}
+
+type format struct {
+	ACodec string `json:"acodec"`
+	VCodec string `json:"vcodec"`
+	Ext    string `json:"ext"`
+	URL    string `json:"url"`
+}
+
+type DownloadOptions struct {
+	// Volume is a multiplier of the output loudness, 0 and 1 keep the original one.
+	Volume float64
+	// Start is a position to start the track from.
+	Start time.Duration
+	// Encode encodes the audio with libopus even if it is Opus, e.g. if its frames don't fit the player.
+	Encode bool
+	// Logger overrides the logger of the client, e.g. to add the attributes of the track.
+	Logger *slog.Logger
+}
+
+// Fetch returns the metadata of the URL, it is cached if the metadata cache is enabled.
+func (c *Client) Fetch(ctx context.Context, url string) (*FetchResult, error) {
+	if c.cache != nil {
+		if fr, ok := c.cache.get(url); ok {
+			return fr, nil
+		}
+	}
+
+	return c.Refresh(ctx, url)
 }
 
-func Fetch(ctx context.Context, url string) (*FetchResult, error) {
-	metadataCmd := exec.CommandContext(
-		ctx,
-		ytDlpPath,
+// Refresh fetches the metadata of the URL bypassing the cache, e.g. after the stream URLs expired.
+func (c *Client) Refresh(ctx context.Context, url string) (*FetchResult, error) {
+	var fr *FetchResult
+	err := c.retry(ctx, c.cfg.Logger.With("url", url), "fetch", func() (err error) {
+		fr, err = c.fetch(ctx, url)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.cache != nil {
+		c.cache.put(url, fr)
+	}
+	return fr, nil
+}
+
+func (c *Client) fetch(ctx context.Context, url string) (*FetchResult, error) {
+	if c.cfg.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.FetchTimeout)
+		defer cancel()
+	}
+
+	args := []string{
 		// see comment below about ignoring errors for playlists
 		"--ignore-errors",
 		"--no-call-home",
-		"--no-cache-dir",
+	}
+	args = append(args, c.cacheArgs()...)
+	args = append(args,
 		"--skip-download",
 		"--restrict-filenames",
 		// provide URL via stdin for security, youtube-dl has some run command args
 		"--batch-file", "-",
 		"-J",
 	)
+	metadataCmd := exec.CommandContext(ctx, c.cfg.YtDlpPath, args...)
 
 	var infoBuf bytes.Buffer
 	var errBuf bytes.Buffer
+	stderrLog := subprocess.NewLineLogger(c.cfg.Logger.With("url", url), "yt-dlp")
+	defer stderrLog.Flush()
 
 	metadataCmd.Stdin = strings.NewReader(url)
 	metadataCmd.Stdout = &infoBuf
-	metadataCmd.Stderr = &errBuf
+	metadataCmd.Stderr = io.MultiWriter(&errBuf, stderrLog)
 
-	if err := metadataCmd.Run(); err != nil {
+	start := time.Now()
+	err := metadataCmd.Run()
+	metrics.FetchDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		var ytDlpErr *Error
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			ytDlpErr = &Error{Kind: ErrTimeout, Err: err}
+		} else {
+			ytDlpErr = newError(err, errBuf.Bytes())
+		}
+		metrics.Failures.WithLabelValues("yt-dlp", failureCategory(ytDlpErr)).Inc()
+		return nil, ytDlpErr
+	}
+
+	fr := &FetchResult{}
+	if err := fr.UnmarshalJSON(infoBuf.Bytes()); err != nil {
 		return nil, err
 	}
+	if fr.URL == "" {
+		fr.URL = url
+	}
 
-	return &FetchResult{
-		rawInfo: infoBuf.Bytes(),
-	}, nil
+	return fr, nil
 }
-func (fr *FetchResult) Download(ctx context.Context, w io.WriteCloser) error {
-	ffmpegStdin, ytDlpStdout, err := os.Pipe()
-	if err != nil {
+
+// Expired reports whether the stream URLs expire before the download can start.
+func (fr *FetchResult) Expired() bool {
+	return !fr.Expires.IsZero() && time.Until(fr.Expires) < time.Minute
+}
+
+// Key identifies the video, e.g. youtube:dQw4w9WgXcQ, it is the canonical URL if the extractor doesn't report the ID.
+func (fr *FetchResult) Key() string {
+	return fr.key
+}
+
+// MarshalJSON returns the info fetched by yt-dlp, so the result can be stored and downloaded later.
+func (fr *FetchResult) MarshalJSON() ([]byte, error) {
+	return fr.rawInfo, nil
+}
+
+func (fr *FetchResult) UnmarshalJSON(data []byte) error {
+	var info videoInfo
+	if err := json.Unmarshal(data, &info); err != nil {
 		return err
 	}
 
-	ffmpegCmd := exec.CommandContext(
-		ctx,
-		ffmpegPath,
-		"-i", "pipe:",
-		"-vn",
-		"-acodec", "libopus",
-		"-f", "ogg",
-		"pipe:",
-	)
-	ffmpegCmd.Cancel = func() error {
-		defer w.Close()
-		return ffmpegCmd.Process.Signal(os.Interrupt)
+	fr.rawInfo = append([]byte(nil), data...)
+	fr.Title = info.Title
+	fr.URL = info.WebpageURL
+	fr.Duration = time.Duration(info.Duration * float64(time.Second))
+	fr.key = canonicalURL(fr.URL)
+	if info.ExtractorKey != "" && info.ID != "" {
+		fr.key = strings.ToLower(info.ExtractorKey) + ":" + info.ID
 	}
-	ffmpegCmd.Stdin = ffmpegStdin
-	ffmpegCmd.Stdout = w
-	// ffmpegCmd.Stderr = io.Discard
+	fr.OpusAudio, fr.webmOpus = false, false
+	urls := []string{info.URL}
+	for _, f := range info.Formats {
+		if f.ACodec == "opus" && f.VCodec == "none" {
+			fr.OpusAudio = true
+			fr.webmOpus = fr.webmOpus || f.Ext == "webm"
+		}
+		urls = append(urls, f.URL)
+	}
+	fr.Expires = streamExpiry(urls)
 
-	ytDlpCmd := exec.CommandContext(
-		ctx,
-		ytDlpPath,
-		"--no-call-home",
-		"--no-cache-dir",
+	return nil
+}
+
+// Passthrough reports whether the Opus audio is downloaded without encoding at the volume.
+func (fr *FetchResult) Passthrough(volume float64) bool {
+	return fr.OpusAudio && (volume == 0 || volume == 1)
+}
+
+// Download writes the audio of the video to w. If the volume isn't changed, WebM Opus audio is written
+// as is and other Opus audio is remuxed to Ogg, other formats are encoded to Ogg Opus with libopus.
+// Expired metadata is fetched again, failures before the audio is written are retried.
+func (c *Client) Download(ctx context.Context, fr *FetchResult, w io.WriteCloser, opts DownloadOptions) error {
+	log := opts.Logger
+	if log == nil {
+		log = c.cfg.Logger.With("url", fr.URL)
+	}
+
+	if fr.Expired() {
+		log.Info("stream URLs expired, fetching the metadata again")
+		fresh, err := c.Refresh(ctx, fr.URL)
+		if err != nil {
+			return err
+		}
+		fr = fresh
+	}
+
+	out := newProgressWriter(w)
+	return c.retry(ctx, log, "download", func() error {
+		err := c.download(ctx, log, fr, out, opts)
+		if err != nil && out.Written() > 0 {
+			// the written audio can't be taken back
+			return &permanentError{err: err}
+		}
+		return err
+	})
+}
+
+// download runs a single attempt, it is canceled if the audio stalls.
+func (c *Client) download(ctx context.Context, log *slog.Logger, fr *FetchResult, w *progressWriter, opts DownloadOptions) error {
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go watch(attemptCtx, cancel, w, c.cfg.DownloadStartTimeout, c.cfg.StallTimeout)
+
+	err := c.runDownload(attemptCtx, log, fr, w, opts)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(attemptCtx), errStalled) {
+		timeout := c.cfg.StallTimeout
+		if w.Written() == 0 {
+			timeout = c.cfg.DownloadStartTimeout
+		}
+		err = &Error{Kind: ErrTimeout, Message: "no audio for " + timeout.String(), Err: errStalled}
+		metrics.Failures.WithLabelValues("yt-dlp", failureCategory(err)).Inc()
+	}
+	return err
+}
+
+func (c *Client) runDownload(ctx context.Context, log *slog.Logger, fr *FetchResult, w io.WriteCloser, opts DownloadOptions) error {
+	passthrough := !opts.Encode && fr.Passthrough(opts.Volume)
+	// yt-dlp writes WebM as is, seeking needs ffmpeg
+	direct := passthrough && fr.webmOpus && opts.Start == 0
+	log.Debug("downloading the audio", "passthrough", passthrough, "direct", direct)
+
+	// https://github.com/yt-dlp/yt-dlp/issues/979#issuecomment-919629354
+	formatSelector := "ba/ba*"
+	switch {
+	case direct:
+		formatSelector = "ba[acodec=opus][ext=webm]"
+	case passthrough:
+		formatSelector = "ba[acodec=opus]"
+	}
+
+	ytDlpArgs := []string{"--no-call-home"}
+	ytDlpArgs = append(ytDlpArgs, c.cacheArgs()...)
+	ytDlpArgs = append(ytDlpArgs,
 		"--ignore-errors",
 		"--newline",
 		"--restrict-filenames",
 		"--load-info", "-",
-		// https://github.com/yt-dlp/yt-dlp/issues/979#issuecomment-919629354
-		"-f", "ba/ba*",
+		"-f", formatSelector,
 		"--format-sort", "aext:opus",
 		"-o", "-",
 	)
+	ytDlpCmd := exec.CommandContext(ctx, c.cfg.YtDlpPath, ytDlpArgs...)
 	ytDlpCmd.Cancel = func() error {
 		return ytDlpCmd.Process.Signal(os.Interrupt)
 	}
+	ytDlpCmd.WaitDelay = killDelay
 	ytDlpCmd.Stdin = bytes.NewReader(fr.rawInfo)
+	var ytDlpStderr bytes.Buffer
+	ytDlpStderrLog := subprocess.NewLineLogger(log, "yt-dlp")
+	defer ytDlpStderrLog.Flush()
+	ytDlpCmd.Stderr = io.MultiWriter(&ytDlpStderr, ytDlpStderrLog)
+
+	if direct {
+		ytDlpCmd.Cancel = func() error {
+			defer w.Close()
+			return ytDlpCmd.Process.Signal(os.Interrupt)
+		}
+		ytDlpCmd.Stdout = w
+		if err := downloadError(ctx, ytDlpCmd.Run(), ytDlpStderr.Bytes()); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	ffmpegStdin, ytDlpStdout, err := os.Pipe()
+	if err != nil {
+		return err
+	}
 	ytDlpCmd.Stdout = ytDlpStdout
-	// ytDlpCmd.Stderr = io.Discard
 
-	if err := ffmpegCmd.Start(); err != nil {
+	var ffmpegArgs []string
+	if opts.Start > 0 {
+		ffmpegArgs = append(ffmpegArgs, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', 3, 64))
+	}
+	ffmpegArgs = append(ffmpegArgs,
+		"-i", "pipe:",
+		"-vn",
+	)
+	if passthrough {
+		ffmpegArgs = append(ffmpegArgs, "-acodec", "copy")
+	} else {
+		if opts.Volume != 0 && opts.Volume != 1 {
+			ffmpegArgs = append(ffmpegArgs, "-af", "volume="+strconv.FormatFloat(opts.Volume, 'f', 2, 64))
+		}
+		ffmpegArgs = append(ffmpegArgs, "-acodec", "libopus")
+	}
+	ffmpegArgs = append(ffmpegArgs,
+		"-f", "ogg",
+		"pipe:",
+	)
+
+	ffmpegCmd := exec.CommandContext(ctx, c.cfg.FfmpegPath, ffmpegArgs...)
+	ffmpegCmd.Cancel = func() error {
+		defer w.Close()
+		return ffmpegCmd.Process.Signal(os.Interrupt)
+	}
+	ffmpegCmd.WaitDelay = killDelay
+	ffmpegCmd.Stdin = ffmpegStdin
+	ffmpegCmd.Stdout = w
+	ffmpegStderrLog := subprocess.NewLineLogger(log, "ffmpeg")
+	defer ffmpegStderrLog.Flush()
+	ffmpegCmd.Stderr = ffmpegStderrLog
+
+	err = ffmpegCmd.Start()
+	// the pipe ends are inherited by the child processes
+	ffmpegStdin.Close()
+	if err != nil {
+		ytDlpStdout.Close()
 		return err
 	}
 	if err := ytDlpCmd.Start(); err != nil {
+		ytDlpStdout.Close()
+		ffmpegCmd.Wait()
 		return err
 	}
 
@@ -103,12 +410,25 @@ func (fr *FetchResult) Download(ctx context.Context, w io.WriteCloser) error {
 	ytDlpStdout.Close()
 	ffmpegErr := ffmpegCmd.Wait()
 
-	if ytDlpErr != nil {
-		return ytDlpErr
+	if err := downloadError(ctx, ytDlpErr, ytDlpStderr.Bytes()); err != nil {
+		return err
 	}
 	if ffmpegErr != nil {
+		if ctx.Err() == nil {
+			metrics.Failures.WithLabelValues("ffmpeg", failureCategory(ffmpegErr)).Inc()
+		}
 		return ffmpegErr
 	}
 
 	return ctx.Err()
 }
+
+// downloadError classifies the failure of yt-dlp, failures caused by the canceled context aren't counted.
+func downloadError(ctx context.Context, err error, stderr []byte) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	ytDlpErr := newError(err, stderr)
+	metrics.Failures.WithLabelValues("yt-dlp", failureCategory(ytDlpErr)).Inc()
+	return ytDlpErr
+}
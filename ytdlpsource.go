@@ -0,0 +1,276 @@
+package discobot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"discobot/audiocache"
+	"discobot/ogg/opus"
+	"discobot/ytdlp"
+
+	"golang.org/x/exp/slog"
+)
+
+const ytdlpSourceName = "yt-dlp"
+
+// ytdlpSource resolves URLs with yt-dlp and converts the audio with ffmpeg.
+type ytdlpSource struct {
+	client *ytdlp.Client
+	// cache is nil if the audio cache is disabled.
+	cache *audiocache.Cache
+}
+
+func (s ytdlpSource) Name() string {
+	return ytdlpSourceName
+}
+
+func (s ytdlpSource) Resolve(ctx context.Context, query string) (*Track, error) {
+	video, err := s.client.Fetch(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return newYtDlpTrack(video), nil
+}
+
+// Refresh fetches the metadata of the track again bypassing the cache.
+func (s ytdlpSource) Refresh(ctx context.Context, track *Track) (*Track, error) {
+	video, err := s.client.Refresh(ctx, track.URL)
+	if err != nil {
+		return nil, err
+	}
+	return newYtDlpTrack(video), nil
+}
+
+func newYtDlpTrack(video *ytdlp.FetchResult) *Track {
+	data, _ := video.MarshalJSON()
+	return &Track{
+		Source:   ytdlpSourceName,
+		Title:    video.Title,
+		URL:      video.URL,
+		Duration: video.Duration,
+		Seekable: video.Duration > 0,
+		Data:     data,
+	}
+}
+
+func (s ytdlpSource) Open(ctx context.Context, track *Track, opts OpenOptions) (io.ReadCloser, error) {
+	var video ytdlp.FetchResult
+	if err := video.UnmarshalJSON(track.Data); err != nil {
+		return nil, err
+	}
+
+	log := opts.Logger
+	if log == nil {
+		log = logger
+	}
+
+	key := audioCacheKey(&video, opts.Volume)
+	if s.cache != nil && key != "" {
+		if file, ok := s.cache.Open(key); ok {
+			log.Debug("playing the cached audio", "start", opts.Start)
+			if opts.Start == 0 {
+				return file, nil
+			}
+			return newPipeStream(ctx, func(ctx context.Context, w io.WriteCloser) error {
+				defer file.Close()
+				return remuxFrom(ctx, file, w, opts.Start)
+			}), nil
+		}
+	}
+
+	return newPipeStream(ctx, func(ctx context.Context, w io.WriteCloser) error {
+		downloadOpts := ytdlp.DownloadOptions{
+			Volume: opts.Volume,
+			Start:  opts.Start,
+			Logger: opts.Logger,
+		}
+		// only whole tracks are cached, seeks are served from the cache by remuxing
+		if s.cache == nil || key == "" || opts.Start != 0 {
+			return wrapDownloadError(s.download(ctx, log, &video, w, downloadOpts))
+		}
+		return wrapDownloadError(s.downloadToCache(ctx, log, &video, key, w, downloadOpts))
+	}), nil
+}
+
+// downloadError is a failure of the download of the audio, e.g. the stream URLs expired or the connection
+// was reset. The player resumes the track after it, other failures would fail again.
+type downloadError struct {
+	err error
+}
+
+func wrapDownloadError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &downloadError{err: err}
+}
+
+func (e *downloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.err
+}
+
+// downloadToCache writes the audio to w and to the cache, the audio is cached if the download succeeds.
+func (s ytdlpSource) downloadToCache(ctx context.Context, log *slog.Logger, video *ytdlp.FetchResult, key string, w io.WriteCloser, opts ytdlp.DownloadOptions) error {
+	cached, err := s.cache.Create(key)
+	if err != nil {
+		log.Warn("failed to cache the audio", "err", err)
+		return s.download(ctx, log, video, w, opts)
+	}
+
+	tee := &teeWriter{WriteCloser: w, cache: cached}
+	if err := s.download(ctx, log, video, tee, opts); err != nil {
+		cached.Abort()
+		return err
+	}
+	if tee.err != nil {
+		cached.Abort()
+		if !errors.Is(tee.err, audiocache.ErrTooLarge) {
+			log.Warn("failed to cache the audio", "err", tee.err)
+		}
+		return nil
+	}
+	if err := cached.Commit(); err != nil {
+		log.Warn("failed to cache the audio", "err", err)
+		return nil
+	}
+	log.Debug("cached the audio", "key", key)
+	return nil
+}
+
+// errFrameSize is returned when a packet of the Opus audio isn't 20ms.
+var errFrameSize = errors.New("opus frame isn't 20ms")
+
+// download writes the audio of the video to w as Ogg Opus. The voice connection sends 20ms frames,
+// so the packets of the audio which isn't encoded by ffmpeg are checked and the audio is encoded
+// from the first packet of another duration.
+func (s ytdlpSource) download(ctx context.Context, log *slog.Logger, video *ytdlp.FetchResult, w io.WriteCloser, opts ytdlp.DownloadOptions) error {
+	if !video.Passthrough(opts.Volume) {
+		return s.client.Download(ctx, video, w, opts)
+	}
+
+	ow, err := opus.NewWriter(w, 2, uint32(time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+	position, err := s.copyFrames(ctx, video, ow, opts)
+	if errors.Is(err, errFrameSize) {
+		log.Info("Opus frames aren't 20ms, encoding the audio", "position", opts.Start+position)
+		opts.Start += position
+		opts.Encode = true
+		_, err = s.copyFrames(ctx, video, ow, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return ow.Close()
+}
+
+// copyFrames downloads the audio and writes its packets to ow. It stops at the first packet
+// which isn't 20ms with errFrameSize and the position of the packet from the start of the download.
+func (s ytdlpSource) copyFrames(ctx context.Context, video *ytdlp.FetchResult, ow *opus.Writer, opts ytdlp.DownloadOptions) (time.Duration, error) {
+	stream := newPipeStream(ctx, func(ctx context.Context, w io.WriteCloser) error {
+		return s.client.Download(ctx, video, w, opts)
+	})
+	defer stream.Close()
+
+	d, err := newPacketDecoder(stream)
+	if err != nil {
+		return 0, err
+	}
+
+	var position time.Duration
+	for {
+		packetReader, err := d.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return position, nil
+		}
+		if err != nil {
+			return position, err
+		}
+		packet, err := io.ReadAll(packetReader)
+		if err != nil {
+			return position, err
+		}
+		if samples, err := opus.PacketSamples(packet); err != nil || samples != frameSamples {
+			return position, errFrameSize
+		}
+		if err := ow.WritePacket(packet); err != nil {
+			return position, err
+		}
+		position += frameDuration
+	}
+}
+
+// audioCacheKey returns the key of the audio in the cache, it is empty for live streams which aren't cached.
+func audioCacheKey(video *ytdlp.FetchResult, volume float64) string {
+	if video.Duration == 0 || video.Key() == "" {
+		return ""
+	}
+	if volume == 0 {
+		volume = 1
+	}
+	return video.Key() + "@" + strconv.FormatFloat(volume, 'f', 2, 64)
+}
+
+// teeWriter copies the stream to the cache, the stream continues if writing to the cache fails.
+type teeWriter struct {
+	io.WriteCloser
+	cache *audiocache.Writer
+	err   error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.WriteCloser.Write(p)
+	if t.err == nil && n > 0 {
+		_, t.err = t.cache.Write(p[:n])
+	}
+	return n, err
+}
+
+// remuxFrom writes the Opus packets of the stream from the start position into an Ogg Opus stream.
+func remuxFrom(ctx context.Context, r io.Reader, w io.Writer, start time.Duration) error {
+	d, err := newPacketDecoder(r)
+	if err != nil {
+		return err
+	}
+	ow, err := opus.NewWriter(w, 2, uint32(time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+
+	var position time.Duration
+	for ctx.Err() == nil {
+		packetReader, err := d.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return ow.Close()
+		}
+		if err != nil {
+			return err
+		}
+		packet, err := io.ReadAll(packetReader)
+		if err != nil {
+			return err
+		}
+		samples, err := opus.PacketSamples(packet)
+		if err != nil {
+			return fmt.Errorf("remux: %w", err)
+		}
+
+		position += time.Duration(samples) * time.Second / 48000
+		if position <= start {
+			continue
+		}
+		if err := ow.WritePacket(packet); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}